@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Formats of published directories.
+const (
+	// formatImage is an image with an empty container configuration.
+	formatImage = "image"
+	// formatArtifact is an OCI 1.1 artifact with an artifactType.
+	formatArtifact = "artifact"
+	// formatFlux is the layout Flux OCIRepository sources expect.
+	formatFlux = "flux"
+)
+
+const (
+	// defaultArtifactType is the artifactType of artifacts unless configured.
+	defaultArtifactType = "application/vnd.kuberik.release-tool.directory.v1"
+	// emptyConfigMediaType marks the empty config of OCI 1.1 artifacts.
+	emptyConfigMediaType types.MediaType = "application/vnd.oci.empty.v1+json"
+	// fluxConfigMediaType and fluxContentMediaType are the media types of
+	// Flux artifacts.
+	fluxConfigMediaType  types.MediaType = "application/vnd.cncf.flux.config.v1+json"
+	fluxContentMediaType types.MediaType = "application/vnd.cncf.flux.content.v1.tar+gzip"
+)
+
+// layerMediaType returns the media type of the layer in format.
+func layerMediaType(format string) (types.MediaType, error) {
+	switch format {
+	case formatImage:
+		return types.DockerLayer, nil
+	case formatArtifact:
+		return types.OCILayer, nil
+	case formatFlux:
+		return fluxContentMediaType, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, expected %s, %s or %s", format, formatImage, formatArtifact, formatFlux)
+	}
+}
+
+// newImage returns the manifest publishing layer in format, annotated with
+// annotations. Images get a container configuration carrying the
+// annotations as labels; artifacts get an empty JSON config of
+// configMediaType and artifactType in their manifest; Flux artifacts get the
+// Flux config media type.
+func newImage(layer v1.Layer, format string, artifactType string, configMediaType types.MediaType, annotations map[string]string) (v1.Image, error) {
+	switch format {
+	case formatArtifact:
+		return partial.CompressedToImage(&artifact{layer: layer, artifactType: artifactType, configMediaType: configMediaType, annotations: annotations})
+	case formatFlux:
+		return partial.CompressedToImage(&artifact{layer: layer, configMediaType: fluxConfigMediaType, annotations: annotations})
+	}
+
+	img, err := mutate.Append(empty.Image, mutate.Addendum{Layer: layer})
+	if err != nil {
+		return nil, err
+	}
+	if img, err = mutate.Config(img, v1.Config{Labels: annotations}); err != nil {
+		return nil, err
+	}
+	return mutate.Annotations(img, annotations).(v1.Image), nil
+}
+
+// imageAnnotations returns the standard OCI annotations of releaseName
+// published from source, created at created, overridden by the custom
+// key=value annotations.
+func imageAnnotations(releaseName string, source releaseSource, created time.Time, custom []string) (map[string]string, error) {
+	annotations := map[string]string{
+		"org.opencontainers.image.title":   releaseName,
+		"org.opencontainers.image.version": source.version,
+		"org.opencontainers.image.created": created.Format(time.RFC3339),
+	}
+	if source.commit != "" {
+		annotations["org.opencontainers.image.revision"] = source.commit
+	}
+	if source.url != "" {
+		annotations["org.opencontainers.image.source"] = source.url
+	}
+	for _, annotation := range custom {
+		key, value, ok := strings.Cut(annotation, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid annotation %q, expected key=value", annotation)
+		}
+		annotations[key] = value
+	}
+	return annotations, nil
+}
+
+// artifact is a single layer OCI manifest with an empty JSON config. The
+// manifest types of go-containerregistry have no artifactType, so the
+// manifest is built directly.
+type artifact struct {
+	layer           v1.Layer
+	artifactType    string
+	configMediaType types.MediaType
+	annotations     map[string]string
+}
+
+// artifactManifest is an OCI image manifest including the artifactType.
+type artifactManifest struct {
+	SchemaVersion int64             `json:"schemaVersion"`
+	MediaType     types.MediaType   `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        v1.Descriptor     `json:"config"`
+	Layers        []v1.Descriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// emptyConfig is the content of the empty config descriptor.
+var emptyConfig = []byte("{}")
+
+func (a *artifact) MediaType() (types.MediaType, error) {
+	return types.OCIManifestSchema1, nil
+}
+
+func (a *artifact) RawConfigFile() ([]byte, error) {
+	return emptyConfig, nil
+}
+
+func (a *artifact) RawManifest() ([]byte, error) {
+	configDigest, configSize, err := v1.SHA256(bytes.NewReader(emptyConfig))
+	if err != nil {
+		return nil, err
+	}
+	layerDigest, err := a.layer.Digest()
+	if err != nil {
+		return nil, err
+	}
+	layerSize, err := a.layer.Size()
+	if err != nil {
+		return nil, err
+	}
+	layerMediaType, err := a.layer.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(artifactManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		ArtifactType:  a.artifactType,
+		Config: v1.Descriptor{
+			MediaType: a.configMediaType,
+			Size:      configSize,
+			Digest:    configDigest,
+		},
+		Layers: []v1.Descriptor{{
+			MediaType: layerMediaType,
+			Size:      layerSize,
+			Digest:    layerDigest,
+		}},
+		Annotations: a.annotations,
+	})
+}
+
+func (a *artifact) LayerByDigest(digest v1.Hash) (partial.CompressedLayer, error) {
+	if layerDigest, err := a.layer.Digest(); err != nil || layerDigest == digest {
+		return a.layer, err
+	}
+	if configDigest, _, err := v1.SHA256(bytes.NewReader(emptyConfig)); err != nil || configDigest == digest {
+		return partial.ConfigLayer(a)
+	}
+	return nil, fmt.Errorf("unknown blob %s", digest)
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// preflightChecks maps the name of each pre-flight check to its
+// implementation. A check returns a description of the violation, or an
+// empty string if it passes.
+var preflightChecks = map[string]func(p *preflight) (string, error){
+	"clean-worktree": checkCleanWorktree,
+	"allowed-branch": checkAllowedBranch,
+	"not-behind":     checkNotBehind,
+	"pushed":         checkPushed,
+}
+
+// preflightCheckOrder is the order pre-flight checks are run and reported in.
+var preflightCheckOrder = []string{"clean-worktree", "allowed-branch", "not-behind", "pushed"}
+
+// preflight holds what the pre-flight checks inspect.
+type preflight struct {
+	ctx      context.Context
+	dir      string
+	commit   string
+	branch   string
+	branches []string
+
+	// remoteBranch caches the result of fetching the remote branch
+	remoteBranch *string
+}
+
+// selectPreflightChecks returns the enabled checks minus the skipped ones,
+// in the order they run.
+func selectPreflightChecks(enabled []string, skipped []string) ([]string, error) {
+	selected := map[string]bool{}
+	for _, name := range enabled {
+		if _, ok := preflightChecks[name]; !ok {
+			return nil, fmt.Errorf("unknown pre-flight check %q, valid checks are: %s", name, strings.Join(preflightCheckOrder, ", "))
+		}
+		selected[name] = true
+	}
+	for _, name := range skipped {
+		if _, ok := preflightChecks[name]; !ok {
+			return nil, fmt.Errorf("unknown pre-flight check %q, valid checks are: %s", name, strings.Join(preflightCheckOrder, ", "))
+		}
+		delete(selected, name)
+	}
+
+	var checks []string
+	for _, name := range preflightCheckOrder {
+		if selected[name] {
+			checks = append(checks, name)
+		}
+	}
+	return checks, nil
+}
+
+// runPreflightChecks runs the given checks and returns an error listing every
+// violation.
+func runPreflightChecks(p *preflight, checks []string) error {
+	var violations []string
+	for _, name := range checks {
+		violation, err := preflightChecks[name](p)
+		if err != nil {
+			return fmt.Errorf("failed to run pre-flight check %s: %v", name, err)
+		}
+		if violation != "" {
+			violations = append(violations, fmt.Sprintf("  - %s: %s", name, violation))
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("pre-flight checks failed (use --skip-check to override):\n%s", strings.Join(violations, "\n"))
+	}
+	return nil
+}
+
+func checkCleanWorktree(p *preflight) (string, error) {
+	status, err := runGit(p.dir, "status", "--porcelain")
+	if err != nil {
+		return "", err
+	}
+	if status != "" {
+		return "working tree has uncommitted changes", nil
+	}
+	return "", nil
+}
+
+func checkAllowedBranch(p *preflight) (string, error) {
+	if p.branch == "" {
+		return "branch could not be determined, pass --branch", nil
+	}
+	if len(p.branches) == 0 {
+		return "", fmt.Errorf("no allowed branches configured, set publish.branches in %s", configFileName)
+	}
+	for _, pattern := range p.branches {
+		matched, err := path.Match(pattern, p.branch)
+		if err != nil {
+			return "", fmt.Errorf("invalid branch pattern %q: %v", pattern, err)
+		}
+		if matched {
+			return "", nil
+		}
+	}
+	return fmt.Sprintf("branch %s is not one of: %s", p.branch, strings.Join(p.branches, ", ")), nil
+}
+
+func checkNotBehind(p *preflight) (string, error) {
+	if p.branch == "" {
+		return "branch could not be determined, pass --branch", nil
+	}
+	remote, err := p.fetchRemoteBranch()
+	if err != nil || remote == "" {
+		// Nothing to be behind of
+		return "", err
+	}
+	if _, err := runGit(p.dir, "merge-base", "--is-ancestor", remote, p.commit); err != nil {
+		return fmt.Sprintf("commit %s is behind %s", p.commit, remote), nil
+	}
+	return "", nil
+}
+
+func checkPushed(p *preflight) (string, error) {
+	if p.branch == "" {
+		return "branch could not be determined, pass --branch", nil
+	}
+	remote, err := p.fetchRemoteBranch()
+	if err != nil {
+		return "", err
+	}
+	if remote == "" {
+		return fmt.Sprintf("branch %s does not exist on origin", p.branch), nil
+	}
+	if _, err := runGit(p.dir, "merge-base", "--is-ancestor", p.commit, remote); err != nil {
+		return fmt.Sprintf("commit %s is not contained in %s", p.commit, remote), nil
+	}
+	return "", nil
+}
+
+// fetchRemoteBranch updates the remote-tracking ref of the released branch
+// and returns its name, or an empty string if origin has no such branch.
+func (p *preflight) fetchRemoteBranch() (string, error) {
+	if p.remoteBranch != nil {
+		return *p.remoteBranch, nil
+	}
+
+	remote := ""
+	heads, err := runGitRemote(p.ctx, p.dir, "ls-remote", "--heads", "origin", "refs/heads/"+p.branch)
+	if err != nil {
+		return "", fmt.Errorf("failed to list remote branches: %v", err)
+	}
+	if heads != "" {
+		remote = "refs/remotes/origin/" + p.branch
+		if _, err := runGitRemote(p.ctx, p.dir, "fetch", "--no-tags", "origin", "+refs/heads/"+p.branch+":"+remote); err != nil {
+			return "", fmt.Errorf("failed to fetch remote branch: %v", err)
+		}
+	}
+	p.remoteBranch = &remote
+	return remote, nil
+}
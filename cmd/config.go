@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileName is the name of the project configuration file, looked up at
+// the root of the git repository unless --config is given.
+const configFileName = ".release-tool.yaml"
+
+// config is the project configuration read from configFileName.
+type config struct {
+	Publish publishConfig `yaml:"publish"`
+	// Components holds per-component settings keyed by release name.
+	Components map[string]componentConfig `yaml:"components"`
+	// Registries holds per-registry settings keyed by registry host, such
+	// as ghcr.io or registry.example.com:5000.
+	Registries map[string]registryConfig `yaml:"registries"`
+}
+
+// publishConfig configures the publish command.
+type publishConfig struct {
+	// Checks lists the pre-flight checks that must pass before publishing.
+	Checks []string `yaml:"checks"`
+	// Branches lists the branch patterns releases may be published from,
+	// used by the allowed-branch check.
+	Branches []string `yaml:"branches"`
+}
+
+// componentConfig configures the release of a single component.
+type componentConfig struct {
+	// VersionFiles lists files that record the component's version and are
+	// updated in a release commit by publish.
+	VersionFiles []versionFile `yaml:"versionFiles"`
+	// CommitMessage is the template of the release commit message.
+	CommitMessage string `yaml:"commitMessage"`
+}
+
+// versionFile describes where a version is recorded in a file.
+type versionFile struct {
+	// Path is relative to the repository root.
+	Path string `yaml:"path"`
+	// Format is one of yaml, json or regex.
+	Format string `yaml:"format"`
+	// Key is the dotted path of the version for the yaml and json formats,
+	// with list indices as numbers, e.g. spec.versions.0.name.
+	Key string `yaml:"key"`
+	// Pattern is a regular expression for the regex format. The first
+	// capture group of every match is replaced by the version.
+	Pattern string `yaml:"pattern"`
+	// Value is the template of the written value, {{.Version}} by default.
+	Value string `yaml:"value"`
+}
+
+// loadConfig reads the project configuration for the repository containing
+// dir. An explicit path must exist; otherwise a missing configuration file
+// yields an empty configuration.
+func loadConfig(dir string, path string) (*config, error) {
+	cfg := &config{}
+	if path == "" {
+		root, err := runGit(dir, "rev-parse", "--show-toplevel")
+		if err != nil {
+			// Not a git repository, nothing to load
+			return cfg, nil
+		}
+		path = filepath.Join(root, configFileName)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return cfg, nil
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	for host, registry := range cfg.Registries {
+		registry.resolvePaths(filepath.Dir(path))
+		cfg.Registries[host] = registry
+	}
+	if err := cfg.Publish.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// validate rejects check settings that would make every release fail.
+func (p publishConfig) validate() error {
+	for _, check := range p.Checks {
+		if check == "allowed-branch" && len(p.Branches) == 0 {
+			return fmt.Errorf("publish.checks enables allowed-branch but publish.branches is empty")
+		}
+	}
+	return nil
+}
+
+// loadCommandConfig loads the project configuration honouring the --config
+// flag inherited from the root command, if any.
+func loadCommandConfig(cmd *cobra.Command, dir string) (*config, error) {
+	path := ""
+	if flag := cmd.Flag("config"); flag != nil {
+		path = flag.Value.String()
+	}
+	return loadConfig(dir, path)
+}
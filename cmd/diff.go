@@ -0,0 +1,413 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+)
+
+// maxDiffSize is the size up to which text files get a unified diff.
+const maxDiffSize = 1 << 20
+
+// Kinds of changes.
+const (
+	changeAdded    = "added"
+	changeRemoved  = "removed"
+	changeModified = "modified"
+)
+
+// diffFile is a file of a published directory.
+type diffFile struct {
+	typeflag byte
+	mode     int64
+	linkname string
+	size     int64
+	digest   [sha256.Size]byte
+	// content is kept for files up to maxDiffSize
+	content []byte
+	text    bool
+}
+
+// diffSide is one of the compared images.
+type diffSide struct {
+	Reference   string `json:"reference"`
+	Digest      string `json:"digest"`
+	annotations map[string]string
+	config      map[string]string
+	files       map[string]*diffFile
+}
+
+// valueChange is a changed annotation or config value.
+type valueChange struct {
+	Key    string `json:"key"`
+	Change string `json:"change"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// fileChange is a changed file.
+type fileChange struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	// Details describes changes besides the content, e.g. of the mode
+	Details []string `json:"details,omitempty"`
+	Binary  bool     `json:"binary,omitempty"`
+	Diff    string   `json:"diff,omitempty"`
+}
+
+// imageDiff holds the differences between two images.
+type imageDiff struct {
+	From        *diffSide     `json:"from"`
+	To          *diffSide     `json:"to"`
+	Annotations []valueChange `json:"annotations"`
+	Config      []valueChange `json:"config"`
+	Files       []fileChange  `json:"files"`
+}
+
+// loadDiffSide resolves reference and reads the annotations, config and
+// files of the image it names.
+func loadDiffSide(access *registryAccess, reference string) (*diffSide, error) {
+	ref, err := resolveReference(access, reference)
+	if err != nil {
+		return nil, err
+	}
+	img, err := remote.Image(ref, access.remoteOpts...)
+	if err != nil {
+		return nil, access.error("pull "+ref.String(), ref.Context().Registry, err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image digest: %v", err)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest of %s: %v", ref, err)
+	}
+	rawConfig, err := img.RawConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config of %s: %v", ref, err)
+	}
+
+	side := &diffSide{
+		Reference:   ref.String(),
+		Digest:      digest.String(),
+		annotations: manifest.Annotations,
+		config:      map[string]string{"mediaType": string(manifest.Config.MediaType)},
+		files:       map[string]*diffFile{},
+	}
+	var config any
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config of %s: %v", ref, err)
+	}
+	flattenJSON(side.config, "config", config)
+
+	err = readLayers(img, func(r io.Reader) error {
+		return readDiffFiles(side.files, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return side, nil
+}
+
+// flattenJSON records the scalar values of value in values by their dotted
+// path below prefix.
+func flattenJSON(values map[string]string, prefix string, value any) {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, child := range value {
+			flattenJSON(values, prefix+"."+key, child)
+		}
+	case []any:
+		for i, child := range value {
+			flattenJSON(values, fmt.Sprintf("%s.%d", prefix, i), child)
+		}
+	case nil:
+	default:
+		encoded, _ := json.Marshal(value)
+		if s, ok := value.(string); ok {
+			encoded = []byte(s)
+		}
+		values[prefix] = string(encoded)
+	}
+}
+
+// readDiffFiles adds the entries of the uncompressed layer r to files, by
+// their name without a trailing slash.
+func readDiffFiles(files map[string]*diffFile, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read layer: %v", err)
+		}
+		file := &diffFile{typeflag: header.Typeflag, mode: header.Mode, linkname: header.Linkname, size: header.Size}
+		if header.Typeflag == tar.TypeReg {
+			hash := sha256.New()
+			var content bytes.Buffer
+			if _, err := io.Copy(io.MultiWriter(hash, &limitedBuffer{buffer: &content, limit: maxDiffSize}), tr); err != nil {
+				return fmt.Errorf("failed to read %s: %v", header.Name, err)
+			}
+			copy(file.digest[:], hash.Sum(nil))
+			if header.Size <= maxDiffSize {
+				file.content = content.Bytes()
+				file.text, _ = isText(bytes.NewReader(file.content))
+			}
+		}
+		files[strings.TrimSuffix(header.Name, "/")] = file
+	}
+}
+
+// limitedBuffer keeps the first limit bytes written to it.
+type limitedBuffer struct {
+	buffer *bytes.Buffer
+	limit  int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buffer.Len(); room > 0 {
+		b.buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// diffImages compares two images.
+func diffImages(from *diffSide, to *diffSide) (*imageDiff, error) {
+	diff := &imageDiff{
+		From:        from,
+		To:          to,
+		Annotations: diffValues(from.annotations, to.annotations),
+		Config:      diffValues(from.config, to.config),
+		Files:       []fileChange{},
+	}
+
+	for _, path := range sortedKeys(from.files, to.files) {
+		a, b := from.files[path], to.files[path]
+		switch {
+		case a == nil:
+			diff.Files = append(diff.Files, fileChange{Path: path, Change: changeAdded})
+		case b == nil:
+			diff.Files = append(diff.Files, fileChange{Path: path, Change: changeRemoved})
+		default:
+			change, err := diffFiles(path, a, b)
+			if err != nil {
+				return nil, err
+			}
+			if change != nil {
+				diff.Files = append(diff.Files, *change)
+			}
+		}
+	}
+	return diff, nil
+}
+
+// diffFiles compares the versions of a file, returning nil if they are the
+// same.
+func diffFiles(path string, a *diffFile, b *diffFile) (*fileChange, error) {
+	change := &fileChange{Path: path, Change: changeModified}
+	if a.typeflag != b.typeflag {
+		change.Details = append(change.Details, fmt.Sprintf("type %s -> %s", entryTypeName(a.typeflag), entryTypeName(b.typeflag)))
+	}
+	if a.mode != b.mode {
+		change.Details = append(change.Details, fmt.Sprintf("mode %04o -> %04o", a.mode, b.mode))
+	}
+	if a.linkname != b.linkname {
+		change.Details = append(change.Details, fmt.Sprintf("target %s -> %s", a.linkname, b.linkname))
+	}
+
+	if a.typeflag == tar.TypeReg && b.typeflag == tar.TypeReg && a.digest != b.digest {
+		switch {
+		case a.size > maxDiffSize || b.size > maxDiffSize:
+			change.Details = append(change.Details, "content too large to diff")
+		case !a.text || !b.text:
+			change.Binary = true
+		default:
+			unified, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+				A:        splitLines(a.content),
+				B:        splitLines(b.content),
+				FromFile: "a/" + path,
+				ToFile:   "b/" + path,
+				Context:  3,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to diff %s: %v", path, err)
+			}
+			change.Diff = unified
+		}
+	} else if len(change.Details) == 0 {
+		return nil, nil
+	}
+	return change, nil
+}
+
+// splitLines splits content into lines keeping their line endings, without
+// the empty line difflib.SplitLines adds after a final newline.
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// entryTypeName names the type of a tar entry.
+func entryTypeName(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeReg:
+		return "file"
+	case tar.TypeDir:
+		return "directory"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hard link"
+	default:
+		return fmt.Sprintf("type %q", typeflag)
+	}
+}
+
+// diffValues compares two sets of values by key.
+func diffValues(from map[string]string, to map[string]string) []valueChange {
+	changes := []valueChange{}
+	for _, key := range sortedKeys(from, to) {
+		a, inFrom := from[key]
+		b, inTo := to[key]
+		switch {
+		case !inFrom:
+			changes = append(changes, valueChange{Key: key, Change: changeAdded, To: b})
+		case !inTo:
+			changes = append(changes, valueChange{Key: key, Change: changeRemoved, From: a})
+		case a != b:
+			changes = append(changes, valueChange{Key: key, Change: changeModified, From: a, To: b})
+		}
+	}
+	return changes
+}
+
+// sortedKeys returns the keys of both maps in order.
+func sortedKeys[V any](a map[string]V, b map[string]V) []string {
+	var keys []string
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// print writes the differences for humans.
+func (d *imageDiff) print(w io.Writer) {
+	fmt.Fprintf(w, "--- %s (%s)\n", d.From.Reference, d.From.Digest)
+	fmt.Fprintf(w, "+++ %s (%s)\n", d.To.Reference, d.To.Digest)
+	if len(d.Annotations) == 0 && len(d.Config) == 0 && len(d.Files) == 0 {
+		fmt.Fprintln(w, "No differences")
+		return
+	}
+
+	printValues := func(title string, changes []valueChange) {
+		if len(changes) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\n%s:\n", title)
+		for _, change := range changes {
+			switch change.Change {
+			case changeAdded:
+				fmt.Fprintf(w, "  + %s: %s\n", change.Key, change.To)
+			case changeRemoved:
+				fmt.Fprintf(w, "  - %s: %s\n", change.Key, change.From)
+			default:
+				fmt.Fprintf(w, "  ~ %s: %s -> %s\n", change.Key, change.From, change.To)
+			}
+		}
+	}
+	printValues("Annotations", d.Annotations)
+	printValues("Config", d.Config)
+
+	if len(d.Files) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nFiles:")
+	for _, change := range d.Files {
+		marker := map[string]string{changeAdded: "A", changeRemoved: "D", changeModified: "M"}[change.Change]
+		details := ""
+		if len(change.Details) > 0 {
+			details = " (" + strings.Join(change.Details, ", ") + ")"
+		}
+		fmt.Fprintf(w, "  %s %s%s\n", marker, change.Path, details)
+	}
+	for _, change := range d.Files {
+		switch {
+		case change.Binary:
+			fmt.Fprintf(w, "\nBinary files a/%s and b/%s differ\n", change.Path, change.Path)
+		case change.Diff != "":
+			fmt.Fprintf(w, "\n%s", change.Diff)
+		}
+	}
+}
+
+func NewOciDiffCmd() *cobra.Command {
+	var registry registryFlags
+	var jsonOutput bool
+	cmd := &cobra.Command{
+		Use:   "diff [reference] [reference]",
+		Short: "Show the differences between two published versions",
+		Long: `Compare two images or artifacts published with oci, e.g. repo:1.4.2 and
+repo:1.4.3, and report the added, removed and modified files with unified
+diffs of text files, as well as the differences of the manifest annotations
+and the config.
+
+References are resolved like those of oci pull, so tags, digests and version
+constraints work. --json prints the differences as JSON.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCommandConfig(cmd, "")
+			if err != nil {
+				return err
+			}
+			access, err := registry.access(cmd, cfg, args[0])
+			if err != nil {
+				return err
+			}
+			from, err := loadDiffSide(access, args[0])
+			if err != nil {
+				return err
+			}
+			to, err := loadDiffSide(access, args[1])
+			if err != nil {
+				return err
+			}
+			diff, err := diffImages(from, to)
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(diff)
+			}
+			diff.print(cmd.OutOrStdout())
+			return nil
+		},
+	}
+
+	addRegistryFlags(cmd, &registry)
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the differences as JSON")
+	return cmd
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// branchEnvVars lists the CI environment variables that carry the name of the
+// branch being built, in order of precedence. They are consulted when the
+// branch cannot be read from git itself, e.g. on a detached HEAD.
+var branchEnvVars = []string{
+	"CI_COMMIT_BRANCH", // GitLab CI
+	"GITHUB_HEAD_REF",  // GitHub Actions, pull requests
+	"GITHUB_REF_NAME",  // GitHub Actions, pushes
+	"BUILDKITE_BRANCH", // Buildkite
+	"CIRCLE_BRANCH",    // CircleCI
+	"BRANCH_NAME",      // Jenkins
+}
+
+// runGit runs a git command in dir and returns its trimmed standard output.
+// An empty dir runs the command in the current working directory.
+func runGit(dir string, args ...string) (string, error) {
+	return runGitContext(context.Background(), dir, args...)
+}
+
+// gitStopDelay is how long an interrupted git command may take to exit
+// before it is killed.
+const gitStopDelay = 5 * time.Second
+
+// runGitContext is runGit, interrupting the command when ctx is done.
+func runGitContext(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	// Let git clean up its lock files before killing it
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = gitStopDelay
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// resolveCommit returns the full hash of the commit ref points at.
+func resolveCommit(dir string, ref string) (string, error) {
+	commit, err := runGit(dir, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s to a commit: %v", ref, err)
+	}
+	return commit, nil
+}
+
+// resolveBranch returns the branch being released. An explicit branch always
+// wins. Otherwise the branch is derived from ref when it names a local or
+// remote-tracking branch, and finally from the CI environment. An empty
+// string is returned when the branch cannot be determined.
+func resolveBranch(dir string, ref string, branch string) (string, error) {
+	if branch != "" {
+		return branch, nil
+	}
+
+	fullName, err := runGit(dir, "rev-parse", "--symbolic-full-name", ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to get current branch: %v", err)
+	}
+	for _, prefix := range []string{"refs/heads/", "refs/remotes/origin/"} {
+		if strings.HasPrefix(fullName, prefix) {
+			return strings.TrimPrefix(fullName, prefix), nil
+		}
+	}
+
+	for _, env := range branchEnvVars {
+		if env == "GITHUB_REF_NAME" && os.Getenv("GITHUB_REF_TYPE") == "tag" {
+			continue
+		}
+		if value := os.Getenv(env); value != "" {
+			return value, nil
+		}
+	}
+	return "", nil
+}
+
+// isGitRepository reports whether dir is inside a git work tree.
+func isGitRepository(dir string) bool {
+	_, err := runGit(dir, "rev-parse", "--is-inside-work-tree")
+	return err == nil
+}
+
+// ensureVersionTags makes sure the history and the version tags of name are
+// available locally, so that version detection does not silently start over
+// from 0.0.0 in shallow or tagless CI clones. Shallow clones are unshallowed
+// and missing tags are fetched from origin; with fetch disabled a shallow
+// clone is an error instead. With remoteTags set, the local name/v* tags are
+// replaced by the ones on origin, which become the source of truth.
+func ensureVersionTags(ctx context.Context, dir string, name string, fetch bool, remoteTags bool) error {
+	_, err := runGit(dir, "remote", "get-url", "origin")
+	hasOrigin := err == nil
+
+	shallow, err := runGit(dir, "rev-parse", "--is-shallow-repository")
+	if err != nil {
+		return fmt.Errorf("failed to check for shallow repository: %v", err)
+	}
+	if shallow == "true" {
+		if !fetch || !hasOrigin {
+			return fmt.Errorf("repository is a shallow clone, so version tags and history may be missing; run 'git fetch --unshallow --tags' or allow fetching")
+		}
+		if _, err := runGitRemote(ctx, dir, "fetch", "--unshallow", "--tags", "origin"); err != nil {
+			return fmt.Errorf("failed to fetch history of shallow clone: %v", err)
+		}
+	}
+
+	if remoteTags {
+		if !hasOrigin {
+			return fmt.Errorf("remote tags requested but no origin remote is configured")
+		}
+		refspec := fmt.Sprintf("+refs/tags/%s/v*:refs/tags/%s/v*", name, name)
+		if _, err := runGitRemote(ctx, dir, "fetch", "--prune", "--no-tags", "origin", refspec); err != nil {
+			return fmt.Errorf("failed to fetch remote tags: %v", err)
+		}
+		return nil
+	}
+
+	if !fetch || !hasOrigin {
+		return nil
+	}
+	tags, err := runGit(dir, "tag", "--list", name+"/v*")
+	if err != nil {
+		return fmt.Errorf("failed to list tags: %v", err)
+	}
+	if tags == "" {
+		if _, err := runGitRemote(ctx, dir, "fetch", "--tags", "origin"); err != nil {
+			return fmt.Errorf("failed to fetch tags: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os/exec"
+	pathpkg "path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gitTreeEntry is an entry of a git tree as listed by git ls-tree.
+type gitTreeEntry struct {
+	mode   string
+	kind   string
+	object string
+	size   int64
+	name   string
+}
+
+// listGitTree returns the entries below the directory dir as committed in
+// commit, recursively, by the parent directory's name ("" for the root).
+func listGitTree(dir string, commit string) (map[string][]gitTreeEntry, error) {
+	prefix, err := runGit(dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate directory in repository: %v", err)
+	}
+	tree := commit + ":" + prefix
+	if _, err := runGit(dir, "cat-file", "-e", tree); err != nil {
+		return nil, fmt.Errorf("directory %s does not exist in commit %s", strings.TrimSuffix(prefix, "/"), commit)
+	}
+	output, err := runGit(dir, "ls-tree", "--full-tree", "-r", "-t", "-z", "--long", tree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files of commit %s: %v", commit, err)
+	}
+
+	children := map[string][]gitTreeEntry{}
+	for _, line := range strings.Split(output, "\x00") {
+		if line == "" {
+			continue
+		}
+		// <mode> SP <type> SP <object> SP+ <size> TAB <path>
+		info, name, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected git ls-tree output: %q", line)
+		}
+		entry := gitTreeEntry{mode: fields[0], kind: fields[1], object: fields[2], name: name}
+		if fields[3] != "-" {
+			if entry.size, err = strconv.ParseInt(fields[3], 10, 64); err != nil {
+				return nil, fmt.Errorf("unexpected git ls-tree output: %q", line)
+			}
+		}
+		parent := pathpkg.Dir(name)
+		if parent == "." {
+			parent = ""
+		}
+		children[parent] = append(children[parent], entry)
+	}
+	return children, nil
+}
+
+// addGitTree adds the directory tree below dir as committed in commit. The
+// entries are ordered like those of the working tree, so a clean checkout
+// packages the same layer either way.
+func (l *layerPlanner) addGitTree(dir string, commit string) error {
+	children, err := listGitTree(dir, commit)
+	if err != nil {
+		return err
+	}
+	var addTree func(parent string) error
+	addTree = func(parent string) error {
+		entries := children[parent]
+		sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+		for _, entry := range entries {
+			if err := l.addGitEntry(dir, entry, addTree); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return addTree("")
+}
+
+// addGitEntry adds a committed file, calling addTree for the content of
+// directories.
+func (l *layerPlanner) addGitEntry(dir string, entry gitTreeEntry, addTree func(parent string) error) error {
+	if l.opts.ignore.ignored(entry.name, entry.kind == "tree") {
+		return nil
+	}
+	// Committed files have no modification time of their own
+	header := &tar.Header{
+		Name:    entry.name,
+		ModTime: clampModTime(l.opts.modTime, l.opts.modTime),
+		Format:  tar.FormatPAX,
+	}
+
+	switch {
+	case entry.kind == "tree":
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+		header.Mode = 0755
+		l.entries = append(l.entries, layerEntry{header: header})
+		return addTree(entry.name)
+
+	case entry.mode == "120000":
+		target, err := runGit(dir, "cat-file", "blob", entry.object)
+		if err != nil {
+			return fmt.Errorf("failed to read symlink %s: %v", entry.name, err)
+		}
+		l.addSymlink(header, target)
+		return nil
+
+	case entry.kind == "blob":
+		header.Typeflag = tar.TypeReg
+		header.Size = entry.size
+		header.Mode = 0644
+		if entry.mode == "100755" {
+			header.Mode = 0755
+		}
+		return l.addFile(entry.name, header, func() (io.ReadCloser, error) {
+			return openGitBlob(dir, entry.object)
+		})
+
+	default:
+		// Submodules are separate repositories
+		l.warn("skipping %s: unsupported git object type %s", entry.name, entry.kind)
+		return nil
+	}
+}
+
+// commandReader reads the output of a running command.
+type commandReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+// Close stops reading and waits for the command to exit.
+func (r *commandReader) Close() error {
+	r.ReadCloser.Close()
+	return r.cmd.Wait()
+}
+
+// openGitBlob streams the content of a git blob.
+func openGitBlob(dir string, object string) (io.ReadCloser, error) {
+	cmd := exec.Command("git", "cat-file", "blob", object)
+	cmd.Dir = dir
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to read git object %s: %v", object, err)
+	}
+	return &commandReader{ReadCloser: stdout, cmd: cmd}, nil
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+)
+
+// ociIgnoreFileName is the file in the packaged directory listing the paths
+// left out of the layer.
+const ociIgnoreFileName = ".ociignore"
+
+// defaultIgnorePatterns are left out of every layer unless re-included.
+var defaultIgnorePatterns = []string{".git"}
+
+// ignorePattern is a pattern in gitignore syntax.
+type ignorePattern struct {
+	// segments holds the path segments to match, "**" matching any number of
+	// them
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// parseIgnorePattern parses a line of an ignore file. Blank lines and
+// comments yield no pattern.
+func parseIgnorePattern(line string) (*ignorePattern, error) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	original := line
+
+	p := &ignorePattern{}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil, fmt.Errorf("invalid pattern %q", original)
+	}
+
+	// Patterns with a slash are relative to the packaged directory, others
+	// match at any depth
+	if !strings.Contains(line, "/") {
+		line = "**/" + line
+	}
+	p.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	for _, segment := range p.segments {
+		if _, err := pathpkg.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", original, err)
+		}
+	}
+	return p, nil
+}
+
+// matches reports whether the slash-separated path name matches the pattern.
+func (p *ignorePattern) matches(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return matchSegments(p.segments, strings.Split(name, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, where "**"
+// matches zero or more segments.
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if matched, _ := pathpkg.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// ignoreRules decides which files are left out of a layer, with gitignore
+// semantics: the last matching pattern wins, "!" negates a pattern, and
+// nothing below an ignored directory can be re-included.
+type ignoreRules struct {
+	patterns []*ignorePattern
+	// gitIgnored holds the paths git ignores, when respecting .gitignore
+	gitIgnored map[string]bool
+}
+
+// newIgnoreRules returns the rules for packaging dir, from the working tree
+// or, if set, as committed in commit. The defaults come first, then the
+// .ociignore of dir, the excludes and finally the includes, which re-include
+// what the others exclude. With gitignore, the files git ignores are left out
+// as well, unless a pattern re-includes them.
+func newIgnoreRules(dir string, commit string, gitignore bool, excludes []string, includes []string) (*ignoreRules, error) {
+	rules := &ignoreRules{}
+	add := func(source string, lines ...string) error {
+		for _, line := range lines {
+			pattern, err := parseIgnorePattern(line)
+			if err != nil {
+				return fmt.Errorf("%s: %v", source, err)
+			}
+			if pattern != nil {
+				rules.patterns = append(rules.patterns, pattern)
+			}
+		}
+		return nil
+	}
+
+	if err := add("default ignore patterns", defaultIgnorePatterns...); err != nil {
+		return nil, err
+	}
+	content, err := readOciIgnore(dir, commit)
+	if err != nil {
+		return nil, err
+	}
+	if err := add(ociIgnoreFileName, strings.Split(content, "\n")...); err != nil {
+		return nil, err
+	}
+	if err := add("--exclude", excludes...); err != nil {
+		return nil, err
+	}
+	for _, include := range includes {
+		if err := add("--include", "!"+strings.TrimPrefix(include, "!")); err != nil {
+			return nil, err
+		}
+	}
+
+	if gitignore {
+		if !isGitRepository(dir) {
+			return nil, fmt.Errorf("--gitignore requires a git repository")
+		}
+		// Let git apply its own rules, including those of parent directories,
+		// .git/info/exclude and core.excludesFile
+		output, err := runGit(dir, "ls-files", "-z", "--others", "--ignored", "--exclude-standard", "--directory")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files ignored by git: %v", err)
+		}
+		rules.gitIgnored = map[string]bool{}
+		for _, path := range strings.Split(output, "\x00") {
+			if path != "" {
+				rules.gitIgnored[strings.TrimSuffix(path, "/")] = true
+			}
+		}
+	}
+	return rules, nil
+}
+
+// readOciIgnore returns the content of the .ociignore of dir, if any.
+func readOciIgnore(dir string, commit string) (string, error) {
+	if commit != "" {
+		object := commit + ":./" + ociIgnoreFileName
+		if _, err := runGit(dir, "cat-file", "-e", object); err != nil {
+			return "", nil
+		}
+		content, err := runGit(dir, "cat-file", "blob", object)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %v", ociIgnoreFileName, err)
+		}
+		return content, nil
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, ociIgnoreFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read %s: %v", ociIgnoreFileName, err)
+	}
+	return string(content), nil
+}
+
+// ignored reports whether the slash-separated path name is left out.
+func (r *ignoreRules) ignored(name string, isDir bool) bool {
+	if r == nil {
+		return false
+	}
+	ignored := r.gitIgnored[name]
+	for _, pattern := range r.patterns {
+		if pattern.matches(name, isDir) {
+			ignored = !pattern.negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnorePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		isDir   bool
+		want    bool
+	}{
+		{"*.swp", "file.swp", false, true},
+		{"*.swp", "dir/file.swp", false, true},
+		{"*.swp", "file.swp.txt", false, false},
+		{"node_modules/", "node_modules", true, true},
+		{"node_modules/", "node_modules", false, false},
+		{"node_modules/", "web/node_modules", true, true},
+		{"/secrets", "secrets", false, true},
+		{"/secrets", "dir/secrets", false, false},
+		{"docs/*.md", "docs/README.md", false, true},
+		{"docs/*.md", "docs/api/README.md", false, false},
+		{"docs/**/*.md", "docs/api/README.md", false, true},
+		{"docs/**/*.md", "docs/README.md", false, true},
+		{"**/build", "a/b/build", true, true},
+		{"build/**", "build/out/bin", false, true},
+		{`\#notes`, "#notes", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			pattern, err := parseIgnorePattern(tt.pattern)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, pattern.matches(tt.name, tt.isDir))
+		})
+	}
+
+	for _, line := range []string{"", "   ", "# comment"} {
+		pattern, err := parseIgnorePattern(line)
+		require.NoError(t, err)
+		assert.Nil(t, pattern)
+	}
+	_, err := parseIgnorePattern("[")
+	assert.Error(t, err)
+}
@@ -0,0 +1,451 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// layerOptions configures how a directory is packaged into a layer.
+type layerOptions struct {
+	// templates holds the values substituted in file contents.
+	templates *templateContext
+	// modTime is the latest modification time recorded in the layer; newer
+	// files are clamped to it.
+	modTime time.Time
+	// dereference packages the targets of symlinks instead of the links.
+	dereference bool
+	// gitCommit packages the directory as committed in this commit instead
+	// of the working tree.
+	gitCommit string
+	// ignore leaves files out of the layer.
+	ignore *ignoreRules
+	// templateInclude restricts placeholder substitution to files matching
+	// one of these globs instead of all text files.
+	templateInclude []string
+	// strict fails packaging if placeholders remain in any text file after
+	// substitution.
+	strict bool
+	// verbose receives per-file details when set.
+	verbose io.Writer
+	// mediaType is the media type of the layer, if not the default.
+	mediaType types.MediaType
+	// warnings receives warnings about entries that are skipped or may not
+	// work as expected.
+	warnings io.Writer
+}
+
+// layerModTime returns the time file modification times are clamped to:
+// SOURCE_DATE_EPOCH if set, otherwise the commit time, and the Unix epoch
+// outside of git repositories.
+func layerModTime(commitTime time.Time) (time.Time, error) {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %v", err)
+		}
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	if commitTime.IsZero() {
+		return time.Unix(0, 0).UTC(), nil
+	}
+	return commitTime, nil
+}
+
+// layerEntry is an entry of a layer, planned before the layer is written.
+type layerEntry struct {
+	header *tar.Header
+	// open returns a regular file's content
+	open func() (io.ReadCloser, error)
+	// template is set for regular files that get placeholders substituted
+	template bool
+	// substitutions counts the substituted placeholders by name
+	substitutions map[string]int
+}
+
+// newLayer returns a layer packaging the directory tree below dir. The tree
+// is walked once up front; the layer content is then streamed from the files
+// whenever it is read, so memory and disk use stay bounded regardless of the
+// size of the files.
+func newLayer(dir string, opts layerOptions) (v1.Layer, error) {
+	entries, err := planLayer(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	var layerOpts []tarball.LayerOption
+	if opts.mediaType != "" {
+		layerOpts = append(layerOpts, tarball.WithMediaType(opts.mediaType))
+	}
+	return tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(writeLayer(pw, entries, opts))
+		}()
+		return pr, nil
+	}, layerOpts...)
+}
+
+// planLayer returns the entries of the directory tree below dir, including
+// directories and symlinks, in lexical order, from the working tree or the
+// commit in opts.gitCommit. The sizes of regular files are computed after
+// placeholder substitution. In strict mode, placeholders left in any text
+// file are reported together as an error.
+func planLayer(dir string, opts layerOptions) ([]layerEntry, error) {
+	planner := &layerPlanner{opts: opts, visited: map[string]bool{}}
+	if opts.gitCommit != "" {
+		if err := planner.addGitTree(dir, opts.gitCommit); err != nil {
+			return nil, err
+		}
+	} else {
+		if opts.dereference {
+			realDir, err := filepath.EvalSymlinks(dir)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve directory: %v", err)
+			}
+			planner.visited[realDir] = true
+		}
+		if err := planner.addDir(dir, ""); err != nil {
+			return nil, err
+		}
+	}
+	if len(planner.unresolved) > 0 {
+		return nil, fmt.Errorf("unresolved placeholders remain after substitution:\n  - %s", strings.Join(planner.unresolved, "\n  - "))
+	}
+	return planner.entries, nil
+}
+
+// writeLayer writes the planned entries to w as a gzipped tarball. The
+// output is reproducible: modification times are clamped to opts.modTime,
+// ownership is dropped, permissions are normalized to 0644 or 0755 and the
+// gzip header carries no timestamp.
+func writeLayer(w io.Writer, entries []layerEntry, opts layerOptions) error {
+	gw, err := gzip.NewWriterLevel(w, gzip.DefaultCompression)
+	if err != nil {
+		return fmt.Errorf("failed to create gzip writer: %v", err)
+	}
+	tw := tar.NewWriter(gw)
+
+	for _, entry := range entries {
+		if err := tw.WriteHeader(entry.header); err != nil {
+			return fmt.Errorf("failed to write tar header: %v", err)
+		}
+		if entry.header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// Stream the content, with placeholders substituted in templates
+		file, err := entry.open()
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %v", entry.header.Name, err)
+		}
+		var content io.Reader = file
+		if entry.template {
+			content, _, err = opts.templates.expand(entry.header.Name, file, len(opts.templateInclude) > 0)
+			if err != nil {
+				file.Close()
+				return fmt.Errorf("failed to template %s: %v", entry.header.Name, err)
+			}
+		}
+		n, err := io.Copy(tw, content)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write contents of %s: %v", entry.header.Name, err)
+		}
+		if n != entry.header.Size {
+			return fmt.Errorf("file %s changed while packaging", entry.header.Name)
+		}
+	}
+
+	// Close writers to ensure all data is written
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		return fmt.Errorf("failed to close gzip writer: %v", err)
+	}
+	return nil
+}
+
+// textSniffLength is how much of a file is inspected to tell text from
+// binary content, the same as git uses.
+const textSniffLength = 8000
+
+// isText reports whether content is text rather than binary, i.e. has no
+// NUL byte in its first textSniffLength bytes.
+func isText(content io.Reader) (bool, error) {
+	head := make([]byte, textSniffLength)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return bytes.IndexByte(head[:n], 0) < 0, nil
+}
+
+// matchesAnyGlob reports whether the slash-separated path matches one of
+// the globs. Globs without a slash match the base name, others the whole
+// path.
+func matchesAnyGlob(name string, globs []string) (bool, error) {
+	for _, glob := range globs {
+		subject := name
+		if !strings.Contains(glob, "/") {
+			subject = pathpkg.Base(name)
+		}
+		matched, err := pathpkg.Match(glob, subject)
+		if err != nil {
+			return false, fmt.Errorf("invalid glob %q: %v", glob, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// layerPlanner collects the entries of a directory tree.
+type layerPlanner struct {
+	opts    layerOptions
+	entries []layerEntry
+	// visited holds the directories being added when dereferencing
+	// symlinks, to detect cycles
+	visited map[string]bool
+	// unresolved lists the placeholders left over per file in strict mode
+	unresolved []string
+}
+
+// addDir adds the entries of the directory at path, named below name in the
+// layer, in lexical order.
+func (l *layerPlanner) addDir(path string, name string) error {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %v", err)
+	}
+	for _, entry := range entries {
+		if err := l.addEntry(filepath.Join(path, entry.Name()), pathpkg.Join(name, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addEntry adds the file at path to the layer as name.
+func (l *layerPlanner) addEntry(path string, name string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %v", err)
+	}
+	isDir := info.IsDir()
+	if info.Mode()&os.ModeSymlink != 0 && l.opts.dereference {
+		if target, err := os.Stat(path); err == nil {
+			isDir = target.IsDir()
+		}
+	}
+	if l.opts.ignore.ignored(name, isDir) {
+		return nil
+	}
+
+	header := &tar.Header{
+		Name:    name,
+		ModTime: clampModTime(info.ModTime(), l.opts.modTime),
+		Format:  tar.FormatPAX,
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		if !l.opts.dereference {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink: %v", err)
+			}
+			l.addSymlink(header, target)
+			return nil
+		}
+
+		// Package what the symlink points at instead
+		info, err = os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to dereference symlink %s: %v", name, err)
+		}
+	}
+
+	switch {
+	case info.IsDir():
+		if l.opts.dereference {
+			realPath, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				return fmt.Errorf("failed to resolve directory: %v", err)
+			}
+			if l.visited[realPath] {
+				return fmt.Errorf("symlink cycle at %s", name)
+			}
+			l.visited[realPath] = true
+			defer delete(l.visited, realPath)
+		}
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+		header.Mode = 0755
+		l.entries = append(l.entries, layerEntry{header: header})
+		return l.addDir(path, name)
+
+	case info.Mode().IsRegular():
+		header.Typeflag = tar.TypeReg
+		header.Size = info.Size()
+		header.Mode = normalizedMode(info.Mode())
+		return l.addFile(name, header, func() (io.ReadCloser, error) {
+			return os.Open(path)
+		})
+
+	default:
+		// Sockets, pipes and devices have no meaning in an artifact
+		l.warn("skipping %s: unsupported file type %s", name, fileTypeName(info.Mode()))
+		return nil
+	}
+}
+
+// addSymlink adds a symlink to target, warning if it points outside the
+// layer.
+func (l *layerPlanner) addSymlink(header *tar.Header, target string) {
+	if filepath.IsAbs(target) || !filepath.IsLocal(pathpkg.Join(pathpkg.Dir(header.Name), filepath.ToSlash(target))) {
+		l.warn("symlink %s points outside the packaged directory: %s", header.Name, target)
+	}
+	header.Typeflag = tar.TypeSymlink
+	header.Linkname = target
+	header.Mode = 0777
+	l.entries = append(l.entries, layerEntry{header: header})
+}
+
+// addFile adds a regular file with the content returned by open to the
+// layer as name, templating it in a dry run to determine its size.
+func (l *layerPlanner) addFile(name string, header *tar.Header, open func() (io.ReadCloser, error)) error {
+	entry := layerEntry{header: header, open: open}
+	file, err := open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", name, err)
+	}
+	text, err := isText(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read file contents: %v", err)
+	}
+	entry.template = text
+	if len(l.opts.templateInclude) > 0 {
+		if entry.template, err = matchesAnyGlob(name, l.opts.templateInclude); err != nil {
+			return err
+		}
+	}
+
+	// Placeholders left over in text are only detected if no strict
+	// placeholder substitution ran, which fails on them itself
+	explicit := len(l.opts.templateInclude) > 0
+	scan := l.opts.strict && text && !(entry.template && explicit && !l.opts.templates.goTemplate)
+	if !entry.template && !scan {
+		l.entries = append(l.entries, entry)
+		return nil
+	}
+
+	// Determine the size after substitution with a dry run
+	file, err = open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", name, err)
+	}
+	defer file.Close()
+	var content io.Reader = file
+	var counts map[string]int
+	if entry.template {
+		content, counts, err = l.opts.templates.expand(name, file, explicit)
+		if err != nil {
+			return fmt.Errorf("failed to template %s: %v", name, err)
+		}
+	}
+	var remaining *placeholderReader
+	if scan {
+		remaining = newPlaceholderCounter(content)
+		content = remaining
+	}
+	header.Size, err = io.Copy(io.Discard, content)
+	if err != nil {
+		return fmt.Errorf("failed to template %s: %v", name, err)
+	}
+	entry.substitutions = counts
+	l.logSubstitutions(name, counts)
+	if remaining != nil && len(remaining.counts) > 0 {
+		l.unresolved = append(l.unresolved, fmt.Sprintf("%s: %s", name, formatSubstitutions(remaining.counts)))
+	}
+
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+// logSubstitutions reports the placeholders substituted in a file in verbose
+// mode.
+func (l *layerPlanner) logSubstitutions(name string, counts map[string]int) {
+	if l.opts.verbose == nil || len(counts) == 0 {
+		return
+	}
+	fmt.Fprintf(l.opts.verbose, "Substituted placeholders in %s: %s\n", name, formatSubstitutions(counts))
+}
+
+// formatSubstitutions lists substitution counts by placeholder name.
+func formatSubstitutions(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("$(%s) x%d", name, counts[name])
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (l *layerPlanner) warn(format string, args ...any) {
+	if l.opts.warnings != nil {
+		fmt.Fprintf(l.opts.warnings, "Warning: "+format+"\n", args...)
+	}
+}
+
+// fileTypeName describes the type of a file that cannot be packaged.
+func fileTypeName(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	default:
+		return "irregular file"
+	}
+}
+
+// normalizedMode maps a file mode to 0755 if it is executable by anyone and
+// to 0644 otherwise.
+func normalizedMode(mode os.FileMode) int64 {
+	if mode&0111 != 0 {
+		return 0755
+	}
+	return 0644
+}
+
+// clampModTime returns modTime, or limit if modTime is after it, truncated to
+// whole seconds.
+func clampModTime(modTime time.Time, limit time.Time) time.Time {
+	if modTime.After(limit) {
+		modTime = limit
+	}
+	return modTime.Truncate(time.Second).UTC()
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// releaseNotesRef is the git notes ref release records are stored under.
+const releaseNotesRef = "refs/notes/releases"
+
+// releaseNotesFetchRef holds the release notes fetched from origin until they
+// are merged into releaseNotesRef.
+const releaseNotesFetchRef = "refs/notes/releases-origin"
+
+// releaseRecord describes a published release. Records are stored as JSON in
+// a git note on the released commit, one entry per component and version.
+type releaseRecord struct {
+	Name      string         `json:"name"`
+	Version   string         `json:"version"`
+	Tag       string         `json:"tag"`
+	Commit    string         `json:"commit"`
+	Releaser  string         `json:"releaser,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
+	CIURL     string         `json:"ciUrl,omitempty"`
+	Images    []releaseImage `json:"images,omitempty"`
+	Changelog []string       `json:"changelog,omitempty"`
+}
+
+// releaseImage is an OCI image published for a release.
+type releaseImage struct {
+	Reference string `json:"reference"`
+	Digest    string `json:"digest"`
+}
+
+// addImage adds image to the record, replacing a previous image with the same
+// reference.
+func (r *releaseRecord) addImage(image releaseImage) {
+	for i := range r.Images {
+		if r.Images[i].Reference == image.Reference {
+			r.Images[i] = image
+			return
+		}
+	}
+	r.Images = append(r.Images, image)
+}
+
+// releaseNote is the content of the git note on a released commit.
+type releaseNote struct {
+	Releases []releaseRecord `json:"releases"`
+}
+
+// readReleaseRecords returns the release records attached to commit.
+func readReleaseRecords(dir string, commit string) ([]releaseRecord, error) {
+	if _, err := runGit(dir, "notes", "--ref", releaseNotesRef, "list", commit); err != nil {
+		// No note on this commit
+		return nil, nil
+	}
+	content, err := runGit(dir, "notes", "--ref", releaseNotesRef, "show", commit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read release notes: %v", err)
+	}
+	var note releaseNote
+	if err := json.Unmarshal([]byte(content), &note); err != nil {
+		return nil, fmt.Errorf("failed to parse release notes of %s: %v", commit, err)
+	}
+	return note.Releases, nil
+}
+
+// findReleaseRecord returns the record of name at version, if any.
+func findReleaseRecord(records []releaseRecord, name string, version string) *releaseRecord {
+	for i := range records {
+		if records[i].Name == name && records[i].Version == version {
+			return &records[i]
+		}
+	}
+	return nil
+}
+
+// fetchReleaseNotes merges the release notes on origin into the local ones.
+// Local notes of commits origin has no notes for are kept, while the note of a
+// commit annotated on both sides is taken from origin. It is not an error for
+// origin to have no notes yet.
+func fetchReleaseNotes(ctx context.Context, dir string) error {
+	if _, err := runGitRemote(ctx, dir, "fetch", "--no-tags", "origin", "+"+releaseNotesRef+":"+releaseNotesFetchRef); err != nil {
+		if strings.Contains(err.Error(), "couldn't find remote ref") {
+			return nil
+		}
+		return fmt.Errorf("failed to fetch release notes: %v", err)
+	}
+	if _, err := runGit(dir, "rev-parse", "--verify", "--quiet", releaseNotesRef); err != nil {
+		// No local notes yet, which git notes merge would need an identity for
+		if _, err := runGit(dir, "update-ref", releaseNotesRef, releaseNotesFetchRef); err != nil {
+			return fmt.Errorf("failed to update release notes: %v", err)
+		}
+		return nil
+	}
+	if _, err := runGit(dir, "notes", "--ref", releaseNotesRef, "merge", "--quiet", "--strategy", "theirs", releaseNotesFetchRef); err != nil {
+		return fmt.Errorf("failed to merge release notes from origin: %v", err)
+	}
+	return nil
+}
+
+// recordRelease updates the release record of name at version on commit and
+// pushes the notes to origin, if there is one. A missing record is created
+// with the releaser, timestamp and CI URL of the current run before update is
+// applied. Concurrent updates of the notes ref are retried.
+func recordRelease(ctx context.Context, dir string, commit string, name string, version string, update func(record *releaseRecord)) error {
+	_, err := runGit(dir, "remote", "get-url", "origin")
+	hasOrigin := err == nil
+
+	const attempts = 3
+	for attempt := 1; ; attempt++ {
+		if hasOrigin {
+			if err := fetchReleaseNotes(ctx, dir); err != nil {
+				return err
+			}
+		}
+
+		records, err := readReleaseRecords(dir, commit)
+		if err != nil {
+			return err
+		}
+		record := findReleaseRecord(records, name, version)
+		if record == nil {
+			records = append(records, releaseRecord{
+				Name:      name,
+				Version:   version,
+				Tag:       name + "/v" + version,
+				Commit:    commit,
+				Releaser:  releaser(dir),
+				Timestamp: time.Now().UTC(),
+				CIURL:     ciURL(),
+			})
+			record = &records[len(records)-1]
+		}
+		update(record)
+
+		content, err := json.MarshalIndent(releaseNote{Releases: records}, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode release notes: %v", err)
+		}
+		if _, err := runGit(dir, "notes", "--ref", releaseNotesRef, "add", "-f", "-m", string(content), commit); err != nil {
+			return fmt.Errorf("failed to write release notes: %v", err)
+		}
+
+		if !hasOrigin {
+			return nil
+		}
+		_, err = runGitRemote(ctx, dir, "push", "origin", releaseNotesRef)
+		if err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			return fmt.Errorf("failed to push release notes: %v", err)
+		}
+	}
+}
+
+// releaser identifies who is publishing, preferring the CI user over the git
+// identity.
+func releaser(dir string) string {
+	for _, env := range []string{"GITHUB_ACTOR", "GITLAB_USER_LOGIN", "BUILDKITE_BUILD_CREATOR", "CIRCLE_USERNAME"} {
+		if value := os.Getenv(env); value != "" {
+			return value
+		}
+	}
+	name, _ := runGit(dir, "config", "user.name")
+	email, _ := runGit(dir, "config", "user.email")
+	if email != "" {
+		return strings.TrimSpace(name + " <" + email + ">")
+	}
+	return name
+}
+
+// ciURL returns a link to the CI run publishing the release, if any.
+func ciURL() string {
+	if runID := os.Getenv("GITHUB_RUN_ID"); runID != "" {
+		return fmt.Sprintf("%s/%s/actions/runs/%s", os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), runID)
+	}
+	for _, env := range []string{"CI_PIPELINE_URL", "BUILDKITE_BUILD_URL", "CIRCLE_BUILD_URL", "BUILD_URL"} {
+		if value := os.Getenv(env); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -1,46 +1,41 @@
 package cmd
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
-	"github.com/google/go-containerregistry/pkg/v1/empty"
-	"github.com/google/go-containerregistry/pkg/v1/mutate"
-	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/spf13/cobra"
 )
 
-// getLatestVersionTag returns the version from the latest commit's tag
-func getLatestVersionTag(dir string, name string) (string, error) {
+// getLatestVersionTag returns the version from the latest tag reachable from ref
+func getLatestVersionTag(dir string, name string, ref string) (string, error) {
 	// Check if directory is a git repository
-	gitCheckCmd := exec.Command("git", "rev-parse", "--is-inside-work-tree")
-	gitCheckCmd.Dir = dir
-	if err := gitCheckCmd.Run(); err != nil {
+	if !isGitRepository(dir) {
 		// Not a git repository, return default version
 		return "0.0.0", nil
 	}
 
-	// Get the latest commit's tag
-	cmd := exec.Command("git", "describe", "--tags", "--abbrev=0")
+	// Get the latest tag of this release, ignoring other components' tags
+	cmd := exec.Command("git", "describe", "--tags", "--abbrev=0", "--match", name+"/v*", ref)
 	cmd.Dir = dir
 	output, err := cmd.Output()
 	if err != nil {
 		// No tag found, get the current commit hash
-		hashCmd := exec.Command("git", "rev-parse", "HEAD")
-		hashCmd.Dir = dir
-		hashOutput, err := hashCmd.Output()
-		if err != nil {
-			return "", fmt.Errorf("failed to get commit hash: %v", err)
-		}
-		return strings.TrimSpace(string(hashOutput)), nil
+		return resolveCommit(dir, ref)
 	}
 
 	tag := strings.TrimSpace(string(output))
@@ -48,13 +43,7 @@ func getLatestVersionTag(dir string, name string) (string, error) {
 	prefix := name + "/v"
 	if !strings.HasPrefix(tag, prefix) {
 		// No matching tag found, get the current commit hash
-		hashCmd := exec.Command("git", "rev-parse", "HEAD")
-		hashCmd.Dir = dir
-		hashOutput, err := hashCmd.Output()
-		if err != nil {
-			return "", fmt.Errorf("failed to get commit hash: %v", err)
-		}
-		return strings.TrimSpace(string(hashOutput)), nil
+		return resolveCommit(dir, ref)
 	}
 
 	// Extract version from tag
@@ -64,24 +53,147 @@ func getLatestVersionTag(dir string, name string) (string, error) {
 	return versionStr, nil
 }
 
+// releaseSource describes the revision a release is published from.
+type releaseSource struct {
+	// version is the version of the release, see getLatestVersionTag
+	version string
+	// commit is the commit ref points at, empty outside git repositories
+	commit string
+	// commitTime is the commit time of commit
+	commitTime time.Time
+	// url is the web address of the origin repository, if known
+	url string
+}
+
+// discoverReleaseSource returns the version of name at ref along with the
+// commit, commit time and origin repository it comes from.
+func discoverReleaseSource(dir string, name string, ref string) (releaseSource, error) {
+	version, err := getLatestVersionTag(dir, name, ref)
+	if err != nil {
+		return releaseSource{}, err
+	}
+	source := releaseSource{version: version}
+	if !isGitRepository(dir) {
+		return source, nil
+	}
+
+	if source.commit, err = resolveCommit(dir, ref); err != nil {
+		return releaseSource{}, err
+	}
+	output, err := runGit(dir, "log", "-1", "--format=%ct", source.commit)
+	if err != nil {
+		return releaseSource{}, fmt.Errorf("failed to get commit time: %v", err)
+	}
+	seconds, err := strconv.ParseInt(output, 10, 64)
+	if err != nil {
+		return releaseSource{}, fmt.Errorf("failed to parse commit time: %v", err)
+	}
+	source.commitTime = time.Unix(seconds, 0).UTC()
+	if origin, err := runGit(dir, "remote", "get-url", "origin"); err == nil {
+		source.url = repositoryURL(origin)
+	}
+	return source, nil
+}
+
+// repositoryURL turns a git remote into a web address without credentials,
+// e.g. git@github.com:org/repo.git into https://github.com/org/repo. Remotes
+// without a host, such as local paths, yield an empty string.
+func repositoryURL(remote string) string {
+	if !strings.Contains(remote, "://") {
+		// scp-like syntax: [user@]host:path
+		host, path, ok := strings.Cut(remote, ":")
+		if !ok || strings.Contains(host, "/") {
+			return ""
+		}
+		if _, h, ok := strings.Cut(host, "@"); ok {
+			host = h
+		}
+		remote = "https://" + host + "/" + strings.TrimPrefix(path, "/")
+	}
+	u, err := url.Parse(remote)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		// ssh and git ports don't serve the web interface
+		u.Scheme = "https"
+		u.Host = u.Hostname()
+	}
+	u.User = nil
+	u.Path = strings.TrimSuffix(u.Path, ".git")
+	return u.String()
+}
+
 func NewOciCmd() *cobra.Command {
-	var insecure bool
+	var registry registryFlags
+	var tags []string
+	var floatingTags, allowOverwrite, dereference, verbose bool
+	var templateInclude, setValues []string
+	var goTemplate, strict bool
+	var excludes, includes []string
+	var gitignore, fromGit bool
+	var format, artifactType, configMediaType string
+	var customAnnotations []string
+	var gitRef, outputTarget string
+	var fetch, remoteTags, notes bool
 	cmd := &cobra.Command{
-		Use:   "oci [release-name] [name] [directory]",
+		Use:   "oci [release-name] [repository] [directory]",
 		Short: "Publish a directory as an OCI image",
-		Long:  `Publish a directory as an OCI image using crane.`,
-		Args:  cobra.ExactArgs(3),
+		Long: `Publish a directory as an OCI image using crane.
+
+The image is pushed to the repository with every --tag, which may use the
+{{.Version}}, {{.Major}}, {{.Minor}}, {{.Patch}}, {{.Prerelease}} and {{.Name}}
+templates. Without --tag, "latest" and the version are pushed, or the tag of
+the given reference and the version.
+
+Placeholders such as $(version), $(commit), $(date), $(values.key) set with
+--set and $(image:component) for the image of another component's release are
+replaced in text files, or in the files matching --template-include. In files
+matching --template-include, unknown placeholders are errors and $$( writes a
+literal $(; other text files keep any other $(...), such as shell or Makefile
+syntax, and $$( as they are. With --go-template, the files are rendered as Go
+templates instead, e.g. {{.Version}} or {{image "component"}}; Go template
+files and their output are limited to 16 MiB. Other files are packaged
+byte-for-byte. --strict also checks Go template output and the text files not
+matching --template-include for leftover placeholders.
+
+Files are left out with gitignore-style patterns: .git by default, then the
+patterns in the .ociignore of the directory, --exclude and --include, which
+re-includes files. The last matching pattern wins. With --gitignore, the files
+git ignores are left out as well.
+
+With --from-git, the directory is packaged as committed at --ref, so
+uncommitted changes are left out and past releases can be packaged without a
+checkout.
+
+By default the directory is published as an image with an empty container
+configuration. --format artifact publishes an OCI 1.1 artifact with an
+artifactType and an empty config, and --format flux the layout Flux
+OCIRepository sources expect.
+
+The manifest is annotated with the standard org.opencontainers.image
+annotations: version, revision (the commit), source (the origin repository),
+created (the commit time, or SOURCE_DATE_EPOCH) and title (the release name),
+plus every --annotation. Images carry them as config labels as well.
+
+Registry credentials come from --username with --password-stdin, a bearer
+token in ` + registryTokenEnv + `, the registries section of the project
+configuration, --docker-config or the default Docker credentials, in that
+order; the first two are only sent to the registry of the repository.
+--ca-file adds certificate authorities to trust and --cert/--key
+present a client certificate; the registries section can set these, as well
+as insecure and plainHTTP, per registry.
+
+With --output, the image is saved with its tags to an OCI layout directory
+(` + outputOCILayout + `:<directory>) or a docker save tarball (` + outputTarball + `:<file.tar>)
+instead of being pushed. "oci push-layout" pushes a saved layout later, and
+"oci pull" unpacks a published directory.`,
+		Args: cobra.ExactArgs(3),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			releaseName := args[0]
 			imageName := args[1]
 			dir := args[2]
 
-			// Extract the name part from the image reference
-			ref, err := name.ParseReference(imageName)
-			if err != nil {
-				return fmt.Errorf("failed to parse image reference: %v", err)
-			}
-
 			// Check if directory exists
 			if !filepath.IsAbs(dir) {
 				var err error
@@ -96,144 +208,251 @@ func NewOciCmd() *cobra.Command {
 				return fmt.Errorf("failed to copy directory contents: directory does not exist")
 			}
 
-			// Get the latest version tag
-			latestVersion, err := getLatestVersionTag(dir, releaseName)
+			mediaType, err := layerMediaType(format)
 			if err != nil {
-				return fmt.Errorf("failed to get latest version tag: %v", err)
+				return err
 			}
-
-			// Create a temporary file for the tarball
-			tmpFile, err := os.CreateTemp("", "oci-*.tar.gz")
-			if err != nil {
-				return fmt.Errorf("failed to create temporary file: %v", err)
+			if format != formatArtifact && (cmd.Flags().Changed("artifact-type") || cmd.Flags().Changed("config-media-type")) {
+				return fmt.Errorf("--artifact-type and --config-media-type require --format %s", formatArtifact)
 			}
-			defer os.Remove(tmpFile.Name())
-			defer tmpFile.Close()
-
-			// Create a gzip writer
-			gw := gzip.NewWriter(tmpFile)
-			defer gw.Close()
-
-			// Create a tar writer
-			tw := tar.NewWriter(gw)
-			defer tw.Close()
 
-			// Walk through the directory and add files to the tarball
-			err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
+			// Make sure tags and history are available in shallow or tagless clones
+			if isGitRepository(dir) {
+				if err := ensureVersionTags(cmd.Context(), dir, releaseName, fetch, remoteTags); err != nil {
 					return err
 				}
+			}
 
-				// Skip the root directory
-				if path == dir {
-					return nil
-				}
-
-				// Get the relative path
-				relPath, err := filepath.Rel(dir, path)
-				if err != nil {
-					return fmt.Errorf("failed to get relative path: %v", err)
-				}
-
-				// Create tar header
-				header, err := tar.FileInfoHeader(info, "")
-				if err != nil {
-					return fmt.Errorf("failed to create tar header: %v", err)
-				}
-				header.Name = relPath
-
-				// If it's a regular file, write its contents
-				if info.Mode().IsRegular() {
-					file, err := os.Open(path)
-					if err != nil {
-						return fmt.Errorf("failed to open file: %v", err)
-					}
-					defer file.Close()
-
-					// Read file contents
-					content, err := io.ReadAll(file)
-					if err != nil {
-						return fmt.Errorf("failed to read file contents: %v", err)
-					}
-
-					// Replace $(version) with the latest version
-					contentStr := string(content)
-					contentStr = strings.ReplaceAll(contentStr, "$(version)", latestVersion)
-
-					// Update the header size to match the new content length
-					header.Size = int64(len(contentStr))
-
-					// Write the header with updated size
-					if err := tw.WriteHeader(header); err != nil {
-						return fmt.Errorf("failed to write tar header: %v", err)
-					}
+			// Get the latest version tag and the revision it comes from
+			source, err := discoverReleaseSource(dir, releaseName, gitRef)
+			if err != nil {
+				return fmt.Errorf("failed to get latest version tag: %v", err)
+			}
+			latestVersion := source.version
 
-					// Write the modified content
-					if _, err := tw.Write([]byte(contentStr)); err != nil {
-						return fmt.Errorf("failed to write file contents: %v", err)
-					}
+			// Registry options
+			cfg, err := loadCommandConfig(cmd, dir)
+			if err != nil {
+				return err
+			}
+			access, err := registry.access(cmd, cfg, imageName)
+			if err != nil {
+				return err
+			}
+			nameOpts := access.nameOptions(imageName)
+
+			// Resolve and validate all tags before doing any work
+			tagData := tagTemplateData(releaseName, latestVersion)
+			var extraTags []string
+			if floatingTags {
+				if _, ok := tagData["Major"]; !ok {
+					return fmt.Errorf("floating tags require a semantic version, got %s", latestVersion)
 				}
-
-				return nil
-			})
-
+				extraTags = floatingTagTemplates
+			}
+			tagRefs, versionTags, err := resolveImageTags(imageName, tags, extraTags, tagData, nameOpts...)
 			if err != nil {
-				return fmt.Errorf("failed to create tarball: %v", err)
+				return err
+			}
+			output, err := parseOutput(outputTarget)
+			if err != nil {
+				return err
+			}
+			if output != nil && output.kind == outputTarball && format != formatImage {
+				return fmt.Errorf("%s output requires --format %s", outputTarball, formatImage)
 			}
 
-			// Close writers to ensure all data is written
-			if err := tw.Close(); err != nil {
-				return fmt.Errorf("failed to close tar writer: %v", err)
+			// Package the directory reproducibly
+			var verboseOutput io.Writer
+			if verbose || strict {
+				verboseOutput = cmd.OutOrStdout()
 			}
-			if err := gw.Close(); err != nil {
-				return fmt.Errorf("failed to close gzip writer: %v", err)
+			modTime, err := layerModTime(source.commitTime)
+			if err != nil {
+				return err
+			}
+			var gitCommit string
+			if fromGit {
+				if !isGitRepository(dir) {
+					return fmt.Errorf("--from-git requires a git repository")
+				}
+				if dereference || gitignore {
+					return fmt.Errorf("--from-git cannot be combined with --dereference or --gitignore")
+				}
+				gitCommit = source.commit
 			}
-			if err := tmpFile.Close(); err != nil {
-				return fmt.Errorf("failed to close temporary file: %v", err)
+			ignore, err := newIgnoreRules(dir, gitCommit, gitignore, excludes, includes)
+			if err != nil {
+				return err
 			}
-
-			// Create a new empty image
-			img := empty.Image
-
-			// Add the layer to the image
-			layer, err := tarball.LayerFromFile(tmpFile.Name())
+			templates, err := newTemplateContext(cmd.Context(), dir, gitRef, releaseName, source, modTime, setValues, goTemplate, fetch)
 			if err != nil {
-				return fmt.Errorf("failed to create layer from tarball: %v", err)
+				return err
 			}
-
-			img, err = mutate.Append(img, mutate.Addendum{
-				Layer: layer,
+			layer, err := newLayer(dir, layerOptions{
+				templates:       templates,
+				modTime:         modTime,
+				dereference:     dereference,
+				gitCommit:       gitCommit,
+				ignore:          ignore,
+				templateInclude: templateInclude,
+				strict:          strict,
+				verbose:         verboseOutput,
+				mediaType:       mediaType,
+				warnings:        cmd.ErrOrStderr(),
 			})
 			if err != nil {
-				return fmt.Errorf("failed to append layer to image: %v", err)
+				return fmt.Errorf("failed to create tarball: %v", err)
 			}
 
-			// Push the image
-			opts := []crane.Option{}
-			if insecure {
-				opts = append(opts, crane.Insecure)
+			// Wrap the layer in an image or artifact manifest
+			annotations, err := imageAnnotations(releaseName, source, modTime, customAnnotations)
+			if err != nil {
+				return err
 			}
-
-			// Push with latest tag
-			if err := crane.Push(img, ref.String(), opts...); err != nil {
-				return fmt.Errorf("failed to push image: %v", err)
+			img, err := newImage(layer, format, artifactType, types.MediaType(configMediaType), annotations)
+			if err != nil {
+				return fmt.Errorf("failed to append layer to image: %v", err)
 			}
 
-			// Push with version tag
-			versionRef, err := name.NewTag(strings.TrimSuffix(ref.String(), ":latest") + ":" + latestVersion)
+			// Save the image locally instead of publishing it
+			if output != nil {
+				return output.write(cmd, img, tagRefs, versionTags)
+			}
+			tagRefs, err = pushImage(cmd, access, img, tagRefs, versionTags, latestVersion, allowOverwrite)
 			if err != nil {
-				return fmt.Errorf("failed to create version tag reference: %v", err)
+				return err
 			}
-			if err := crane.Push(img, versionRef.String(), opts...); err != nil {
-				return fmt.Errorf("failed to push version tag: %v", err)
+			digest, err := img.Digest()
+			if err != nil {
+				return fmt.Errorf("failed to get image digest: %v", err)
 			}
 
-			fmt.Fprintf(cmd.OutOrStdout(), "Successfully published directory as OCI image: %s\n", imageName)
-			fmt.Fprintf(cmd.OutOrStdout(), "Added version tag: %s\n", versionRef.String())
+			// Attach the image to the release record of the version tag, if any
+			if notes && isGitRepository(dir) {
+				if commit, err := resolveCommit(dir, releaseName+"/v"+latestVersion); err == nil {
+					err := recordRelease(cmd.Context(), dir, commit, releaseName, latestVersion, func(record *releaseRecord) {
+						for _, tagRef := range tagRefs {
+							record.addImage(releaseImage{Reference: tagRef.String(), Digest: digest.String()})
+						}
+					})
+					if err != nil {
+						fmt.Fprintf(cmd.ErrOrStderr(), "Warning: failed to record release: %v\n", err)
+					}
+				}
+			}
 			return nil
 		},
 	}
 
-	cmd.Flags().BoolVar(&insecure, "insecure", false, "Allow pushing to insecure registries")
+	addRegistryFlags(cmd, &registry)
+	cmd.Flags().StringArrayVar(&tags, "tag", nil, "Tag to push, may be a template such as {{.Major}}.{{.Minor}} (repeatable)")
+	cmd.Flags().BoolVar(&floatingTags, "floating-tags", false, "Also push the X and X.Y tags of version X.Y.Z")
+	cmd.Flags().BoolVar(&allowOverwrite, "allow-overwrite", false, "Allow overwriting an already published version with different content")
+	cmd.Flags().BoolVar(&dereference, "dereference", false, "Package the targets of symlinks instead of the symlinks")
+	cmd.Flags().StringArrayVar(&excludes, "exclude", nil, "Leave out files matching this gitignore-style pattern (repeatable)")
+	cmd.Flags().StringArrayVar(&includes, "include", nil, "Package files matching this gitignore-style pattern even if excluded otherwise (repeatable)")
+	cmd.Flags().BoolVar(&gitignore, "gitignore", false, "Leave out the files git ignores")
+	cmd.Flags().BoolVar(&fromGit, "from-git", false, "Package the directory as committed at --ref instead of the working tree")
+	cmd.Flags().StringArrayVar(&templateInclude, "template-include", nil, "Only substitute placeholders in files matching this glob instead of all text files (repeatable)")
+	cmd.Flags().StringArrayVar(&setValues, "set", nil, "Set a key=value available as $(values.key) or {{.Values.key}} in packaged files (repeatable)")
+	cmd.Flags().BoolVar(&goTemplate, "go-template", false, "Render packaged text files as Go templates instead of substituting $(name) placeholders")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Fail if $(name) placeholders remain in any packaged text file and print the substitutions made in each file")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Print the placeholders substituted in each file")
+	cmd.Flags().StringVar(&format, "format", formatImage, "Manifest layout: image, artifact (OCI 1.1 artifact) or flux (Flux OCIRepository)")
+	cmd.Flags().StringVar(&artifactType, "artifact-type", defaultArtifactType, "artifactType of artifacts")
+	cmd.Flags().StringVar(&configMediaType, "config-media-type", string(emptyConfigMediaType), "Config media type of artifacts")
+	cmd.Flags().StringArrayVar(&customAnnotations, "annotation", nil, "Add a key=value annotation and label, overriding the standard ones (repeatable)")
+	cmd.Flags().StringVar(&gitRef, "ref", "HEAD", "Git ref used to determine the version")
+	cmd.Flags().StringVar(&outputTarget, "output", "", "Save the image to "+outputOCILayout+":<directory> or "+outputTarball+":<file.tar> instead of pushing it")
+	cmd.Flags().BoolVar(&notes, "notes", true, "Record the published image in "+releaseNotesRef)
+	cmd.Flags().BoolVar(&fetch, "fetch", true, "Fetch history and tags from origin in shallow or tagless clones, and the release records looked up by $(image:component)")
+	cmd.Flags().BoolVar(&remoteTags, "remote-tags", false, "Use the version tags on origin as the source of truth")
+	cmd.AddCommand(NewOciPushLayoutCmd())
+	cmd.AddCommand(NewOciPullCmd())
+	cmd.AddCommand(NewOciDiffCmd())
 	return cmd
 }
+
+// pushImage publishes img with tagRefs, which share a repository, as
+// version. Floating tags never move back to an older version, and the tags in
+// versionTags, which name the published version, are only overwritten with
+// different content if allowOverwrite is set. It returns the tags pushed.
+func pushImage(cmd *cobra.Command, access *registryAccess, img v1.Image, tagRefs []name.Tag, versionTags map[string]bool, version string, allowOverwrite bool) ([]name.Tag, error) {
+	remoteOpts := access.remoteOpts
+	repository := tagRefs[0].Context()
+
+	// Never move floating tags such as latest back to an older version
+	existingTags, err := remote.List(repository, remoteOpts...)
+	if err != nil && !isNotFound(err) {
+		return nil, access.error("list existing tags", repository.Registry, err)
+	}
+	tagRefs, skippedTags := selectFloatingTags(tagRefs, version, existingTags)
+	for _, skipped := range skippedTags {
+		fmt.Fprintf(cmd.OutOrStdout(), "Skipped tag %s\n", skipped)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image digest: %v", err)
+	}
+
+	// Published versions are immutable: republishing the same content only
+	// updates the other tags and different content is refused unless
+	// explicitly allowed
+	published := false
+	for _, tagRef := range tagRefs {
+		if !versionTags[tagRef.TagStr()] {
+			continue
+		}
+		existing, err := remote.Head(tagRef, remoteOpts...)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return nil, access.error("check existing version tag", repository.Registry, err)
+		}
+		if existing.Digest == digest {
+			fmt.Fprintf(cmd.OutOrStdout(), "Version %s is already published with digest %s, skipping upload\n", tagRef.String(), digest)
+			published = true
+			continue
+		}
+		if !allowOverwrite {
+			return nil, fmt.Errorf("version %s is already published with digest %s, refusing to overwrite it with %s (use --allow-overwrite to force)", tagRef.String(), existing.Digest, digest)
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: overwriting version %s (was %s)\n", tagRef.String(), existing.Digest)
+	}
+
+	// Upload the image once, then tag the manifest for every other tag.
+	// Without any tag left to push, the image is pushed by digest.
+	var pushRef name.Reference = repository.Digest(digest.String())
+	if len(tagRefs) > 0 {
+		pushRef = tagRefs[0]
+	}
+	if published {
+		if err := remote.Tag(pushRef.(name.Tag), img, remoteOpts...); err != nil {
+			return nil, access.error("push image", repository.Registry, err)
+		}
+	} else if err := remote.Write(pushRef, img, remoteOpts...); err != nil {
+		return nil, access.error("push image", repository.Registry, err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Successfully published directory as OCI image: %s\n", pushRef.String())
+	if len(tagRefs) > 1 {
+		for _, tagRef := range tagRefs[1:] {
+			if err := remote.Tag(tagRef, img, remoteOpts...); err != nil {
+				return nil, access.error("push tag "+tagRef.TagStr(), repository.Registry, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Added version tag: %s\n", tagRef.String())
+		}
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Digest: %s\n", digest)
+
+	return tagRefs, nil
+}
+
+// isNotFound reports whether err is a registry error for a missing resource,
+// e.g. listing the tags of a repository that does not exist yet.
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/spf13/cobra"
+)
+
+// Kinds of local outputs.
+const (
+	// outputOCILayout is an OCI image layout directory.
+	outputOCILayout = "oci-layout"
+	// outputTarball is a tarball in the format of docker save.
+	outputTarball = "tarball"
+)
+
+const (
+	// refNameAnnotation holds the tag of a manifest in an OCI layout.
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+	// imageNameAnnotation holds the full reference of a manifest in an OCI
+	// layout, as containerd records it.
+	imageNameAnnotation = "io.containerd.image.name"
+	// versionTagAnnotation marks the manifests of an OCI layout whose tag
+	// names the published version, which push-layout refuses to overwrite.
+	versionTagAnnotation = "io.kuberik.release-tool.version-tag"
+)
+
+// outputTarget is a local destination of an image.
+type outputTarget struct {
+	kind string
+	path string
+}
+
+// parseOutput parses an --output value of the form kind:path. An empty value
+// yields no target, publishing to the registry.
+func parseOutput(value string) (*outputTarget, error) {
+	if value == "" {
+		return nil, nil
+	}
+	kind, path, ok := strings.Cut(value, ":")
+	if !ok || path == "" || (kind != outputOCILayout && kind != outputTarball) {
+		return nil, fmt.Errorf("invalid output %q, expected %s:<directory> or %s:<file.tar>", value, outputOCILayout, outputTarball)
+	}
+	return &outputTarget{kind: kind, path: path}, nil
+}
+
+// write saves img with every tag of tagRefs. versionTags are the tags naming
+// the published version, see resolveImageTags.
+func (o *outputTarget) write(cmd *cobra.Command, img v1.Image, tagRefs []name.Tag, versionTags map[string]bool) error {
+	var err error
+	switch o.kind {
+	case outputOCILayout:
+		err = writeLayout(o.path, img, tagRefs, versionTags)
+	case outputTarball:
+		refs := map[name.Reference]v1.Image{}
+		for _, tagRef := range tagRefs {
+			refs[tagRef] = img
+		}
+		err = tarball.MultiRefWriteToFile(o.path, refs)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s %s: %v", o.kind, o.path, err)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		return fmt.Errorf("failed to get image digest: %v", err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s %s\n", o.kind, o.path)
+	for _, tagRef := range tagRefs {
+		fmt.Fprintf(cmd.OutOrStdout(), "Added tag: %s\n", tagRef.String())
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Digest: %s\n", digest)
+	return nil
+}
+
+// writeLayout adds img to the OCI layout at path, creating it if needed, once
+// per tag. Manifests already carrying one of the tags are replaced, and the
+// tags in versionTags are marked with versionTagAnnotation.
+func writeLayout(path string, img v1.Image, tagRefs []name.Tag, versionTags map[string]bool) error {
+	p, err := layout.FromPath(path)
+	if err != nil {
+		if p, err = layout.Write(path, empty.Index); err != nil {
+			return err
+		}
+	}
+	for _, tagRef := range tagRefs {
+		annotations := map[string]string{
+			refNameAnnotation:   tagRef.TagStr(),
+			imageNameAnnotation: tagRef.String(),
+		}
+		if versionTags[tagRef.TagStr()] {
+			annotations[versionTagAnnotation] = "true"
+		}
+		if err := p.ReplaceImage(img, match.Annotation(refNameAnnotation, tagRef.TagStr()), layout.WithAnnotations(annotations)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// layoutImage is an image of an OCI layout with its tags.
+type layoutImage struct {
+	image v1.Image
+	tags  []name.Tag
+	// versionTags holds the tags marked with versionTagAnnotation
+	versionTags map[string]bool
+}
+
+// readLayout returns the tagged images of the OCI layout at path, ordered by
+// digest. Tags are pushed to repository, or to the repository they were saved
+// for if empty, parsed with the options of nameOptions.
+func readLayout(path string, repository string, nameOptions func(reference string) []name.Option) ([]layoutImage, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to open OCI layout: %v", err)
+	}
+	p, err := layout.FromPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open OCI layout %s: %v", path, err)
+	}
+	index, err := p.ImageIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCI layout %s: %v", path, err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCI layout %s: %v", path, err)
+	}
+
+	images := map[v1.Hash]*layoutImage{}
+	for _, desc := range manifest.Manifests {
+		if !desc.MediaType.IsImage() {
+			continue
+		}
+		var reference string
+		switch {
+		case repository != "" && desc.Annotations[refNameAnnotation] != "":
+			reference = repository + ":" + desc.Annotations[refNameAnnotation]
+		case repository == "" && desc.Annotations[imageNameAnnotation] != "":
+			reference = desc.Annotations[imageNameAnnotation]
+		default:
+			return nil, fmt.Errorf("manifest %s in OCI layout %s has no tag to push", desc.Digest, path)
+		}
+		tag, err := name.NewTag(reference, nameOptions(reference)...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag of manifest %s: %v", desc.Digest, err)
+		}
+
+		if images[desc.Digest] == nil {
+			image, err := index.Image(desc.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read manifest %s: %v", desc.Digest, err)
+			}
+			images[desc.Digest] = &layoutImage{image: image, versionTags: map[string]bool{}}
+		}
+		images[desc.Digest].tags = append(images[desc.Digest].tags, tag)
+		if desc.Annotations[versionTagAnnotation] == "true" {
+			images[desc.Digest].versionTags[tag.TagStr()] = true
+		}
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("OCI layout %s holds no images", path)
+	}
+
+	var digests []v1.Hash
+	for digest := range images {
+		digests = append(digests, digest)
+	}
+	sort.Slice(digests, func(i, j int) bool { return digests[i].String() < digests[j].String() })
+	var result []layoutImage
+	for _, digest := range digests {
+		result = append(result, *images[digest])
+	}
+	return result, nil
+}
+
+func NewOciPushLayoutCmd() *cobra.Command {
+	var registry registryFlags
+	var allowOverwrite bool
+	cmd := &cobra.Command{
+		Use:   "push-layout [layout] [repository]",
+		Short: "Push an OCI layout saved with --output to a registry",
+		Long: `Push the images of an OCI layout written by oci --output ` + outputOCILayout + ` to a
+registry, with all their tags.
+
+The tags are pushed to the repository they were saved for, or to the given
+repository instead. Like oci, floating tags never move back to an older
+version, taken from the org.opencontainers.image.version annotation, and a
+published version is only overwritten with --allow-overwrite.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := args[0]
+			repository := ""
+			if len(args) > 1 {
+				repository = args[1]
+			}
+
+			cfg, err := loadCommandConfig(cmd, "")
+			if err != nil {
+				return err
+			}
+			access, err := registry.access(cmd, cfg, repository)
+			if err != nil {
+				return err
+			}
+			images, err := readLayout(path, repository, access.nameOptions)
+			if err != nil {
+				return err
+			}
+
+			// Without a repository, explicit credentials are for the single
+			// registry the layout was saved for
+			if repository == "" {
+				registries := map[string]bool{}
+				for _, image := range images {
+					for _, tag := range image.tags {
+						registries[tag.RegistryStr()] = true
+						access.keychain.registry = tag.RegistryStr()
+					}
+				}
+				if len(registries) > 1 && access.keychain.explicit() {
+					return fmt.Errorf("the OCI layout holds tags for several registries, pass a repository to push to with --username or %s", registryTokenEnv)
+				}
+			}
+
+			for _, image := range images {
+				manifest, err := image.image.Manifest()
+				if err != nil {
+					return fmt.Errorf("failed to read manifest: %v", err)
+				}
+				version := manifest.Annotations["org.opencontainers.image.version"]
+
+				// Layouts saved without version tag markers still protect the
+				// tag equal to the version
+				versionTags := image.versionTags
+				if len(versionTags) == 0 {
+					versionTags = map[string]bool{version: version != ""}
+				}
+
+				// Tags saved for different repositories are pushed separately
+				byRepository := map[string][]name.Tag{}
+				var repositories []string
+				for _, tag := range image.tags {
+					repo := tag.Context().String()
+					if byRepository[repo] == nil {
+						repositories = append(repositories, repo)
+					}
+					byRepository[repo] = append(byRepository[repo], tag)
+				}
+				for _, repo := range repositories {
+					if _, err := pushImage(cmd, access, image.image, byRepository[repo], versionTags, version, allowOverwrite); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	}
+
+	addRegistryFlags(cmd, &registry)
+	cmd.Flags().BoolVar(&allowOverwrite, "allow-overwrite", false, "Allow overwriting an already published version with different content")
+	return cmd
+}
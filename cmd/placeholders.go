@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+)
+
+// maxPlaceholderName bounds the length of placeholder names, and with it the
+// amount of input a placeholderReader holds back while looking for the end
+// of a placeholder.
+const maxPlaceholderName = 128
+
+// placeholderReader substitutes $(name) placeholders in a stream. Names
+// consist of letters, digits and "_.:-". A placeholder that lookup fails to
+// resolve is an error; "$$(" escapes a literal "$(". Memory use is bounded
+// by the read buffer size regardless of the size of the stream.
+type placeholderReader struct {
+	r io.Reader
+	// lookup resolves placeholders; without it they are only counted
+	lookup func(name string) (string, error)
+	// known restricts substitution to the names it accepts. Other $(name)
+	// text and "$$(" are then left as they are, as in shell scripts and
+	// Makefiles.
+	known func(name string) bool
+
+	// counts holds the number of substitutions per placeholder name
+	counts map[string]int
+
+	chunk []byte
+	in    []byte // input not yet scanned
+	out   []byte // output not yet returned
+	eof   bool
+	err   error
+}
+
+// newPlaceholderCounter returns a placeholderReader that passes r through
+// unchanged, escapes included, only counting the placeholders in it.
+func newPlaceholderCounter(r io.Reader) *placeholderReader {
+	return newPlaceholderReader(r, nil)
+}
+
+func newPlaceholderReader(r io.Reader, lookup func(name string) (string, error)) *placeholderReader {
+	return &placeholderReader{
+		r:      r,
+		lookup: lookup,
+		counts: map[string]int{},
+		chunk:  make([]byte, 32*1024),
+	}
+}
+
+func (p *placeholderReader) Read(b []byte) (int, error) {
+	for len(p.out) == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+		if p.eof && len(p.in) == 0 {
+			return 0, io.EOF
+		}
+		if !p.eof {
+			n, err := p.r.Read(p.chunk)
+			p.in = append(p.in, p.chunk[:n]...)
+			if err == io.EOF {
+				p.eof = true
+			} else if err != nil {
+				p.err = err
+			}
+		}
+		p.scan()
+	}
+	n := copy(b, p.out)
+	p.out = p.out[n:]
+	return n, nil
+}
+
+// scan moves scanned input to the output, holding back a trailing partial
+// placeholder until more input arrives.
+func (p *placeholderReader) scan() {
+	in := p.in
+	for {
+		start := bytes.Index(in, []byte("$("))
+		if start < 0 {
+			// A trailing "$" may start a placeholder, "$$" an escape
+			keep := 0
+			for !p.eof && keep < 2 && keep < len(in) && in[len(in)-1-keep] == '$' {
+				keep++
+			}
+			p.out = append(p.out, in[:len(in)-keep]...)
+			in = in[len(in)-keep:]
+			break
+		}
+		if start > 0 && in[start-1] == '$' {
+			// Escaped, "$$(" stands for a literal "$(" where placeholders are
+			// substituted strictly
+			if p.lookup == nil || p.known != nil {
+				p.out = append(p.out, in[:start+2]...)
+			} else {
+				p.out = append(p.out, in[:start-1]...)
+				p.out = append(p.out, "$("...)
+			}
+			in = in[start+2:]
+			continue
+		}
+		p.out = append(p.out, in[:start]...)
+		in = in[start:]
+
+		name := in[2:]
+		end := 0
+		for end < len(name) && end <= maxPlaceholderName && isPlaceholderNameByte(name[end]) {
+			end++
+		}
+		if end == len(name) && end <= maxPlaceholderName && !p.eof {
+			// Incomplete, wait for more input
+			break
+		}
+		if end > 0 && end < len(name) && end <= maxPlaceholderName && name[end] == ')' && (p.known == nil || p.known(string(name[:end]))) {
+			placeholder := string(name[:end])
+			if p.lookup == nil {
+				p.out = append(p.out, in[:end+3]...)
+			} else {
+				value, err := p.lookup(placeholder)
+				if err != nil {
+					p.err = err
+					break
+				}
+				p.out = append(p.out, value...)
+			}
+			p.counts[placeholder]++
+			in = name[end+1:]
+			continue
+		}
+
+		// Not a placeholder, such as a shell command substitution or a
+		// Makefile variable, keep "$(" and scan on
+		p.out = append(p.out, in[:2]...)
+		in = in[2:]
+	}
+	p.in = append(p.in[:0], in...)
+}
+
+func isPlaceholderNameByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == ':' || c == '-'
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlaceholderReader(t *testing.T) {
+	lookup := func(name string) (string, error) {
+		values := map[string]string{"version": "1.2.3", "name": "app"}
+		if value, ok := values[name]; ok {
+			return value, nil
+		}
+		return "", fmt.Errorf("unknown placeholder $(%s)", name)
+	}
+
+	tests := []struct {
+		name   string
+		input  string
+		want   string
+		counts map[string]int
+	}{
+		{
+			name:   "placeholders",
+			input:  "version: $(version)\nname: $(name)-$(version)\n",
+			want:   "version: 1.2.3\nname: app-1.2.3\n",
+			counts: map[string]int{"version": 2, "name": 1},
+		},
+		{
+			name:   "non-placeholders are kept",
+			input:  "$(date +%s) $ $( $$ $(version",
+			want:   "$(date +%s) $ $( $$ $(version",
+			counts: map[string]int{},
+		},
+		{
+			name:   "adjacent placeholders",
+			input:  "$(version)$(version)$",
+			want:   "1.2.31.2.3$",
+			counts: map[string]int{"version": 2},
+		},
+		{
+			name:   "escaped placeholders",
+			input:  "$$(version) $$(unknown) $$$(name)",
+			want:   "$(version) $(unknown) $$(name)",
+			counts: map[string]int{},
+		},
+		{
+			name:   "overlong names are not placeholders",
+			input:  "$(" + strings.Repeat("a", maxPlaceholderName+1) + ")",
+			want:   "$(" + strings.Repeat("a", maxPlaceholderName+1) + ")",
+			counts: map[string]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Feed the input a byte at a time to split placeholders across reads
+			r := newPlaceholderReader(iotest.OneByteReader(strings.NewReader(tt.input)), lookup)
+			output, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(output))
+			assert.Equal(t, tt.counts, r.counts)
+		})
+	}
+
+	t.Run("unknown placeholder", func(t *testing.T) {
+		r := newPlaceholderReader(iotest.OneByteReader(strings.NewReader("a $(version) $(unknown) b")), lookup)
+		_, err := io.ReadAll(r)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown placeholder $(unknown)")
+	})
+
+	t.Run("known names only", func(t *testing.T) {
+		input := "$(CC) $(version) $$(version) $$$(name) $(unknown)"
+		r := newPlaceholderReader(iotest.OneByteReader(strings.NewReader(input)), lookup)
+		r.known = func(name string) bool { return name == "version" || name == "name" }
+		output, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "$(CC) 1.2.3 $$(version) $$$(name) $(unknown)", string(output))
+		assert.Equal(t, map[string]int{"version": 1}, r.counts)
+	})
+
+	t.Run("counter", func(t *testing.T) {
+		input := "$(version) $(verison) $$(escaped) $(date +%s) $(version)"
+		r := newPlaceholderCounter(iotest.OneByteReader(strings.NewReader(input)))
+		output, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, input, string(output))
+		assert.Equal(t, map[string]int{"version": 2, "verison": 1}, r.counts)
+	})
+
+	t.Run("large input", func(t *testing.T) {
+		chunk := strings.Repeat("x", 1000) + "$(version)"
+		input := strings.Repeat(chunk, 10000)
+		r := newPlaceholderReader(strings.NewReader(input), lookup)
+		var output bytes.Buffer
+		_, err := io.Copy(&output, r)
+		require.NoError(t, err)
+		assert.Equal(t, strings.ReplaceAll(input, "$(version)", "1.2.3"), output.String())
+		assert.Equal(t, 10000, r.counts["version"])
+	})
+}
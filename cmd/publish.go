@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"os/exec"
 	"strings"
 
 	"github.com/Masterminds/semver/v3"
@@ -10,7 +9,10 @@ import (
 )
 
 func NewPublishCmd() *cobra.Command {
-	return &cobra.Command{
+	var ref, branch string
+	var fetch, remoteTags, notes bool
+	var checks, skipChecks []string
+	cmd := &cobra.Command{
 		Use:   "publish [name]",
 		Short: "Publish a release branch",
 		Long:  `Publish a release branch with the given name.`,
@@ -18,42 +20,63 @@ func NewPublishCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			name := args[0]
 
-			// Get current commit hash
-			headCmd := exec.Command("git", "rev-parse", "HEAD")
-			headOutput, err := headCmd.Output()
+			// Select pre-flight checks from config and flags
+			cfg, err := loadCommandConfig(cmd, "")
 			if err != nil {
-				return fmt.Errorf("failed to get current commit: %v", err)
+				return err
+			}
+			selectedChecks, err := selectPreflightChecks(append(cfg.Publish.Checks, checks...), skipChecks)
+			if err != nil {
+				return err
+			}
+
+			// Make sure tags and history are available in shallow or tagless clones
+			if err := ensureVersionTags(cmd.Context(), "", name, fetch, remoteTags); err != nil {
+				return err
+			}
+
+			// Resolve the commit to release
+			currentCommit, err := resolveCommit("", ref)
+			if err != nil {
+				return err
 			}
-			currentCommit := strings.TrimSpace(string(headOutput))
 
 			// Check if this commit is already tagged with a version tag for this release
-			tagCmd := exec.Command("git", "tag", "--points-at", currentCommit, name+"/v*")
-			output, err := tagCmd.Output()
+			output, err := runGitContext(cmd.Context(), "", "tag", "--points-at", currentCommit, name+"/v*")
 			if err == nil && len(output) > 0 {
 				return fmt.Errorf("no new commits to tag")
 			}
 
-			// Get current branch name
-			branchCmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
-			branchOutput, err := branchCmd.Output()
+			// Get the branch being released
+			currentBranch, err := resolveBranch("", ref, branch)
 			if err != nil {
-				return fmt.Errorf("failed to get current branch: %v", err)
+				return err
+			}
+
+			// Run pre-flight checks before pushing anything
+			err = runPreflightChecks(&preflight{
+				ctx:      cmd.Context(),
+				commit:   currentCommit,
+				branch:   currentBranch,
+				branches: cfg.Publish.Branches,
+			}, selectedChecks)
+			if err != nil {
+				return err
 			}
-			currentBranch := strings.TrimSpace(string(branchOutput))
 
 			// Check if we're on a release branch
 			isReleaseBranch := strings.HasPrefix(currentBranch, "release-"+name+"-")
 
 			// Get latest version from git history
-			logCmd := exec.Command("git", "log", "--pretty=format:%D", "--simplify-by-decoration", "HEAD")
-			logOutput, err := logCmd.Output()
+			logOutput, err := runGitContext(cmd.Context(), "", "log", "--pretty=format:%D", "--simplify-by-decoration", currentCommit)
 			if err != nil {
 				return fmt.Errorf("failed to get git log: %v", err)
 			}
 
 			// Parse tags and find latest version
 			latestVersion := semver.MustParse("0.0.0")
-			lines := strings.Split(string(logOutput), "\n")
+			latestTag := ""
+			lines := strings.Split(logOutput, "\n")
 		find_loop:
 			for _, line := range lines {
 				if line == "" {
@@ -68,6 +91,7 @@ func NewPublishCmd() *cobra.Command {
 						version, err := semver.NewVersion(versionStr)
 						if err == nil {
 							latestVersion = version
+							latestTag = strings.TrimPrefix(tag, "tag: ")
 							break find_loop
 						}
 					}
@@ -75,41 +99,141 @@ func NewPublishCmd() *cobra.Command {
 			}
 
 			var newVersion *semver.Version
+			var releaseBranch string
 
 			if isReleaseBranch {
 				// For patch releases, increment from the current version's patch
 				newVersion = semver.MustParse(fmt.Sprintf("%d.%d.%d", latestVersion.Major(), latestVersion.Minor(), latestVersion.Patch()+1))
-
-				// Push the current branch
-				pushCmd := exec.Command("git", "push", "origin", currentBranch)
-				if err := pushCmd.Run(); err != nil {
-					return fmt.Errorf("failed to push branch: %v", err)
-				}
+				releaseBranch = currentBranch
 			} else {
 				newVersion = semver.MustParse(fmt.Sprintf("%d.%d.%d", latestVersion.Major(), latestVersion.Minor()+1, latestVersion.Patch()))
-				newBranch := fmt.Sprintf("release-%s-%d.%d", name, newVersion.Major(), newVersion.Minor())
-				pushCmd := exec.Command("git", "push", "origin", currentCommit+":refs/heads/"+newBranch)
-				if err := pushCmd.Run(); err != nil {
-					return fmt.Errorf("failed to push branch: %v", err)
+				releaseBranch = fmt.Sprintf("release-%s-%d.%d", name, newVersion.Major(), newVersion.Minor())
+			}
+			tagName := fmt.Sprintf("%s/v%d.%d.%d", name, newVersion.Major(), newVersion.Minor(), newVersion.Patch())
+
+			// Record the version in the configured files with a release commit
+			if component := cfg.Components[name]; len(component.VersionFiles) > 0 {
+				currentCommit, err = createReleaseCommit(currentCommit, component, releaseData{
+					Name:    name,
+					Version: newVersion.String(),
+					Tag:     tagName,
+				})
+				if err != nil {
+					return err
 				}
-				fmt.Fprintf(cmd.OutOrStdout(), "Pushed new release branch: %s\n", newBranch)
+				fmt.Fprintf(cmd.OutOrStdout(), "Created release commit: %s\n", currentCommit)
+			}
+
+			// Push the released commit to the release branch
+			if _, err := runGitRemote(cmd.Context(), "", "push", "origin", currentCommit+":refs/heads/"+releaseBranch); err != nil {
+				return fmt.Errorf("failed to push branch: %v", err)
+			}
+			if !isReleaseBranch {
+				fmt.Fprintf(cmd.OutOrStdout(), "Pushed new release branch: %s\n", releaseBranch)
 			}
 
 			// Create and push a tag for this release
-			tagName := fmt.Sprintf("%s/v%d.%d.%d", name, newVersion.Major(), newVersion.Minor(), newVersion.Patch())
-			tagCmd = exec.Command("git", "tag", "-f", tagName, currentCommit)
-			if err := tagCmd.Run(); err != nil {
+			if _, err := runGitContext(cmd.Context(), "", "tag", "-f", tagName, currentCommit); err != nil {
 				return fmt.Errorf("failed to create tag: %v", err)
 			}
 
 			// Push the tag
-			pushTagCmd := exec.Command("git", "push", "-f", "origin", tagName)
-			if err := pushTagCmd.Run(); err != nil {
+			if _, err := runGitRemote(cmd.Context(), "", "push", "-f", "origin", tagName); err != nil {
 				return fmt.Errorf("failed to push tag: %v", err)
 			}
 
 			fmt.Fprintf(cmd.OutOrStdout(), "Created and pushed tag: %s\n", tagName)
+
+			// Record the release in git notes; the release itself already happened,
+			// so failing to record it is only a warning
+			if notes {
+				err := recordRelease(cmd.Context(), "", currentCommit, name, newVersion.String(), func(record *releaseRecord) {
+					record.Changelog = releaseChangelog("", latestTag, currentCommit)
+				})
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "Warning: failed to record release: %v\n", err)
+				} else {
+					fmt.Fprintf(cmd.OutOrStdout(), "Recorded release in %s\n", releaseNotesRef)
+				}
+			}
 			return nil
 		},
 	}
+
+	cmd.Flags().StringVar(&ref, "ref", "HEAD", "Git ref of the commit to release")
+	cmd.Flags().StringSliceVar(&checks, "check", nil, "Pre-flight checks to run in addition to the configured ones ("+strings.Join(preflightCheckOrder, ", ")+")")
+	cmd.Flags().StringSliceVar(&skipChecks, "skip-check", nil, "Pre-flight checks to skip")
+	cmd.Flags().BoolVar(&fetch, "fetch", true, "Fetch history and tags from origin in shallow or tagless clones")
+	cmd.Flags().BoolVar(&remoteTags, "remote-tags", false, "Use the version tags on origin as the source of truth")
+	cmd.Flags().StringVar(&branch, "branch", "", "Branch being released (detected from the ref or CI environment if empty)")
+	cmd.Flags().BoolVar(&notes, "notes", true, "Record the release in "+releaseNotesRef)
+	return cmd
+}
+
+// createReleaseCommit writes the release version into the component's
+// version files and commits them on top of commit, which must be checked out.
+// The commit is made on a detached HEAD and the checked-out branch, if any, is
+// checked out again afterwards, so that only the release branch the commit is
+// pushed to contains it. It returns the hash of the release commit.
+func createReleaseCommit(commit string, component componentConfig, data releaseData) (release string, err error) {
+	head, err := resolveCommit("", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if head != commit {
+		return "", fmt.Errorf("version files can only be updated when the released commit is checked out")
+	}
+	root, err := runGit("", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", fmt.Errorf("failed to find repository root: %v", err)
+	}
+
+	// Leave the checked-out branch where it is
+	if branch, err := runGit(root, "symbolic-ref", "--quiet", "--short", "HEAD"); err == nil {
+		if _, err := runGit(root, "checkout", "--quiet", "--detach"); err != nil {
+			return "", fmt.Errorf("failed to detach HEAD: %v", err)
+		}
+		defer func() {
+			if _, checkoutErr := runGit(root, "checkout", "--quiet", branch); checkoutErr != nil && err == nil {
+				err = fmt.Errorf("failed to check out %s again: %v", branch, checkoutErr)
+			}
+		}()
+	}
+
+	if err := updateVersionFiles(root, component.VersionFiles, data); err != nil {
+		return "", err
+	}
+
+	messageTemplate := component.CommitMessage
+	if messageTemplate == "" {
+		messageTemplate = defaultCommitMessage
+	}
+	message, err := renderTemplate("commit message", messageTemplate, data)
+	if err != nil {
+		return "", err
+	}
+
+	// Commit only the version files, leaving anything else staged alone
+	args := []string{"commit", "-m", message, "--"}
+	for _, file := range component.VersionFiles {
+		args = append(args, file.Path)
+	}
+	if _, err := runGit(root, args...); err != nil {
+		return "", fmt.Errorf("failed to create release commit: %v", err)
+	}
+	return resolveCommit(root, "HEAD")
+}
+
+// releaseChangelog lists the commits since the previous release tag, or the
+// whole history for a first release.
+func releaseChangelog(dir string, previousTag string, commit string) []string {
+	revisions := commit
+	if previousTag != "" {
+		revisions = previousTag + ".." + commit
+	}
+	output, err := runGit(dir, "log", "--format=%h %s", revisions)
+	if err != nil || output == "" {
+		return nil
+	}
+	return strings.Split(output, "\n")
 }
@@ -97,6 +97,44 @@ func setupTestRepo(t *testing.T) (string, string) {
 	return localDir, remoteDir
 }
 
+// testCommit describes a commit created by initTestRepo.
+type testCommit struct {
+	message string
+	// files are written relative to the repository root and committed along
+	// with everything else in the working tree
+	files map[string]string
+	// tags are created on the commit
+	tags []string
+}
+
+// initTestRepo creates a git repository with the given commits and returns
+// its directory. Commits without files are empty.
+func initTestRepo(t *testing.T, commits ...testCommit) string {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+	git("init")
+	git("config", "user.name", "Test User")
+	git("config", "user.email", "test@example.com")
+
+	for _, commit := range commits {
+		for path, content := range commit.files {
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(content), 0644))
+		}
+		git("add", ".")
+		git("commit", "--allow-empty", "-m", commit.message)
+		for _, tag := range commit.tags {
+			git("tag", tag)
+		}
+	}
+	return dir
+}
+
 func TestPublishCommand(t *testing.T) {
 	// Setup test repository
 	localDir, remoteDir := setupTestRepo(t)
@@ -289,3 +327,251 @@ func TestPublishCommandMultipleVersions(t *testing.T) {
 	assert.NotEqual(t, tag1Commit, tag2Commit, "Tags should point to different commits")
 	assert.NotEqual(t, tag2Commit, tag3Commit, "Tags should point to different commits")
 }
+
+func TestPublishCommandExplicitRef(t *testing.T) {
+	// Setup test repository
+	localDir, remoteDir := setupTestRepo(t)
+
+	// Change to test directory
+	oldDir, err := os.Getwd()
+	require.NoError(t, err)
+	defer os.Chdir(oldDir)
+	require.NoError(t, os.Chdir(localDir))
+
+	// Make branch detection depend only on what the test sets
+	for _, env := range branchEnvVars {
+		t.Setenv(env, "")
+	}
+
+	// First release from master (0.1.0)
+	output, err := executeCommand(NewRootCmd(), "publish", "test")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Created and pushed tag: test/v0.1.0")
+
+	// Commit a patch on the release branch, then go back to master
+	require.NoError(t, exec.Command("git", "checkout", "release-test-0.1").Run())
+	require.NoError(t, os.WriteFile("patch.txt", []byte("patch"), 0644))
+	require.NoError(t, exec.Command("git", "add", "patch.txt").Run())
+	require.NoError(t, exec.Command("git", "commit", "-m", "Patch for 0.1").Run())
+	patchCommit, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	require.NoError(t, err)
+	require.NoError(t, exec.Command("git", "checkout", "master").Run())
+
+	// Release the patch commit without checking it out
+	output, err = executeCommand(NewRootCmd(), "publish", "test", "--ref", strings.TrimSpace(string(patchCommit)), "--branch", "release-test-0.1")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Created and pushed tag: test/v0.1.1")
+
+	tagOutput, err := exec.Command("git", "ls-remote", remoteDir, "refs/tags/test/v0.1.1").Output()
+	require.NoError(t, err)
+	assert.Equal(t, strings.TrimSpace(string(patchCommit)), strings.Split(string(tagOutput), "\t")[0])
+
+	// Commit another patch and release it from a detached HEAD, taking the
+	// branch from the CI environment
+	require.NoError(t, exec.Command("git", "checkout", "release-test-0.1").Run())
+	require.NoError(t, exec.Command("git", "pull", "origin", "release-test-0.1").Run())
+	require.NoError(t, os.WriteFile("patch.txt", []byte("patch 2"), 0644))
+	require.NoError(t, exec.Command("git", "commit", "-am", "Second patch for 0.1").Run())
+	require.NoError(t, exec.Command("git", "checkout", "--detach").Run())
+	t.Setenv("CI_COMMIT_BRANCH", "release-test-0.1")
+
+	output, err = executeCommand(NewRootCmd(), "publish", "test")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Created and pushed tag: test/v0.1.2")
+	assert.NotContains(t, output, "Pushed new release branch")
+
+	branchOutput, err := exec.Command("git", "ls-remote", "--heads", remoteDir, "release-test-0.1").Output()
+	require.NoError(t, err)
+	headCommit, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	require.NoError(t, err)
+	assert.Equal(t, strings.TrimSpace(string(headCommit)), strings.Split(string(branchOutput), "\t")[0])
+}
+
+func TestPublishCommandShallowClone(t *testing.T) {
+	// Setup test repository
+	localDir, remoteDir := setupTestRepo(t)
+
+	// Change to test directory
+	oldDir, err := os.Getwd()
+	require.NoError(t, err)
+	defer os.Chdir(oldDir)
+	require.NoError(t, os.Chdir(localDir))
+
+	// Release 0.1.0 and push an untagged commit to its release branch
+	_, err = executeCommand(NewRootCmd(), "publish", "test")
+	require.NoError(t, err)
+	require.NoError(t, exec.Command("git", "checkout", "release-test-0.1").Run())
+	require.NoError(t, os.WriteFile("patch.txt", []byte("patch"), 0644))
+	require.NoError(t, exec.Command("git", "add", "patch.txt").Run())
+	require.NoError(t, exec.Command("git", "commit", "-m", "Patch for 0.1").Run())
+	require.NoError(t, exec.Command("git", "push", "origin", "release-test-0.1").Run())
+
+	// A shallow clone of the release branch does not see the 0.1.0 tag
+	cloneDir := filepath.Join(t.TempDir(), "clone")
+	require.NoError(t, exec.Command("git", "clone", "--depth", "1", "--branch", "release-test-0.1", "file://"+remoteDir, cloneDir).Run())
+	require.NoError(t, os.Chdir(cloneDir))
+
+	// Without fetching, publishing fails loudly
+	_, err = executeCommand(NewRootCmd(), "publish", "test", "--fetch=false")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "shallow clone")
+
+	// With fetching, history and tags are recovered and the patch is released
+	output, err := executeCommand(NewRootCmd(), "publish", "test")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Created and pushed tag: test/v0.1.1")
+
+	// Remote tags replace stale local ones
+	require.NoError(t, exec.Command("git", "tag", "test/v0.9.0", "HEAD").Run())
+	output, err = executeCommand(NewRootCmd(), "version", "test", "--remote-tags")
+	require.NoError(t, err)
+	assert.Equal(t, "0.1.1\n", output)
+}
+
+func TestPublishCommandPreflightChecks(t *testing.T) {
+	// Setup test repository
+	localDir, _ := setupTestRepo(t)
+
+	// Change to test directory
+	oldDir, err := os.Getwd()
+	require.NoError(t, err)
+	defer os.Chdir(oldDir)
+	require.NoError(t, os.Chdir(localDir))
+
+	// Only allow releasing from main, with everything pushed
+	config := "publish:\n  checks: [clean-worktree, allowed-branch, not-behind, pushed]\n  branches: [main, release-*]\n"
+	require.NoError(t, os.WriteFile(configFileName, []byte(config), 0644))
+	require.NoError(t, exec.Command("git", "add", configFileName).Run())
+	require.NoError(t, exec.Command("git", "commit", "-m", "Add config").Run())
+	require.NoError(t, os.WriteFile("file1.txt", []byte("dirty"), 0644))
+
+	// Every violated check is reported
+	_, err = executeCommand(NewRootCmd(), "publish", "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "clean-worktree: working tree has uncommitted changes")
+	assert.Contains(t, err.Error(), "allowed-branch: branch master is not one of: main, release-*")
+	assert.Contains(t, err.Error(), "pushed: commit")
+	assert.NotContains(t, err.Error(), "not-behind")
+
+	// Individual checks can be skipped
+	_, err = executeCommand(NewRootCmd(), "publish", "test", "--skip-check", "clean-worktree,allowed-branch")
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "clean-worktree")
+	assert.Contains(t, err.Error(), "pushed: commit")
+
+	// Once pushed, only the skipped checks would fail
+	require.NoError(t, exec.Command("git", "push", "origin", "master").Run())
+	output, err := executeCommand(NewRootCmd(), "publish", "test", "--skip-check", "clean-worktree", "--skip-check", "allowed-branch")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Created and pushed tag: test/v0.1.0")
+
+	// Unknown checks are rejected
+	_, err = executeCommand(NewRootCmd(), "publish", "test", "--check", "bogus")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown pre-flight check "bogus"`)
+
+	// The allowed-branch check needs branches to allow
+	config = "publish:\n  checks: [allowed-branch]\n  branches: []\n"
+	require.NoError(t, os.WriteFile(configFileName, []byte(config), 0644))
+	_, err = executeCommand(NewRootCmd(), "publish", "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "publish.checks enables allowed-branch but publish.branches is empty")
+	require.NoError(t, os.WriteFile(configFileName, []byte("publish: {}\n"), 0644))
+	require.NoError(t, exec.Command("git", "commit", "-am", "Drop branches").Run())
+	_, err = executeCommand(NewRootCmd(), "publish", "test", "--check", "allowed-branch")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no allowed branches configured")
+}
+
+func TestPublishCommandVersionFiles(t *testing.T) {
+	// Setup test repository
+	localDir, remoteDir := setupTestRepo(t)
+
+	// Change to test directory
+	oldDir, err := os.Getwd()
+	require.NoError(t, err)
+	defer os.Chdir(oldDir)
+	require.NoError(t, os.Chdir(localDir))
+
+	files := map[string]string{
+		configFileName: `components:
+  test:
+    commitMessage: "chore(release): {{.Name}} {{.Version}}"
+    versionFiles:
+      - path: chart/Chart.yaml
+        format: yaml
+        key: version
+      - path: chart/Chart.yaml
+        format: yaml
+        key: appVersion
+        value: "v{{.Version}}"
+      - path: package.json
+        format: json
+        key: version
+      - path: version.go
+        format: regex
+        pattern: 'Version = "([^"]*)"'
+      - path: version.sh
+        format: regex
+        pattern: 'VERSION=(.*)'
+`,
+		"chart/Chart.yaml": "# The test chart\nname: test\nversion: 0.0.0 # bumped on release\nappVersion: \"v0.0.0\"\n",
+		"package.json":     "{\n  \"name\": \"test\",\n  \"scripts\": {\"version\": \"echo\"},\n  \"version\": \"0.0.0\"\n}\n",
+		"version.go":       "package main\n\nconst Version = \"dev\"\n",
+	}
+	for path, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	require.NoError(t, os.WriteFile("version.sh", []byte("#!/bin/sh\nVERSION=dev\n"), 0755))
+	require.NoError(t, exec.Command("git", "add", ".").Run())
+	require.NoError(t, exec.Command("git", "commit", "-m", "Add version files").Run())
+	released, err := resolveCommit("", "master")
+	require.NoError(t, err)
+
+	output, err := executeCommand(NewRootCmd(), "publish", "test")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Created release commit: ")
+	assert.Contains(t, output, "Created and pushed tag: test/v0.1.0")
+
+	// The tag points at the release commit with the rewritten files
+	message, err := exec.Command("git", "log", "-1", "--format=%s", "test/v0.1.0").Output()
+	require.NoError(t, err)
+	assert.Equal(t, "chore(release): test 0.1.0", strings.TrimSpace(string(message)))
+
+	want := map[string]string{
+		"chart/Chart.yaml": "# The test chart\nname: test\nversion: 0.1.0 # bumped on release\nappVersion: \"v0.1.0\"\n",
+		"package.json":     "{\n  \"name\": \"test\",\n  \"scripts\": {\"version\": \"echo\"},\n  \"version\": \"0.1.0\"\n}\n",
+		"version.go":       "package main\n\nconst Version = \"0.1.0\"\n",
+		"version.sh":       "#!/bin/sh\nVERSION=0.1.0\n",
+	}
+	for path, content := range want {
+		tagged, err := exec.Command("git", "--git-dir", remoteDir, "show", "test/v0.1.0:"+path).Output()
+		require.NoError(t, err)
+		assert.Equal(t, content, string(tagged), path)
+	}
+
+	// Rewritten files keep their permissions
+	treeEntry, err := exec.Command("git", "--git-dir", remoteDir, "ls-tree", "test/v0.1.0", "version.sh").Output()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(treeEntry), "100755 "), string(treeEntry))
+
+	// The checked-out branch does not move, only the release branch gets the
+	// release commit
+	branch, err := exec.Command("git", "symbolic-ref", "--short", "HEAD").Output()
+	require.NoError(t, err)
+	assert.Equal(t, "master", strings.TrimSpace(string(branch)))
+	head, err := resolveCommit("", "master")
+	require.NoError(t, err)
+	assert.Equal(t, released, head)
+	status, err := exec.Command("git", "status", "--porcelain").Output()
+	require.NoError(t, err)
+	assert.Empty(t, string(status))
+
+	// The release branch contains the release commit
+	branchOutput, err := exec.Command("git", "ls-remote", "--heads", remoteDir, "release-test-0.1").Output()
+	require.NoError(t, err)
+	tagOutput, err := exec.Command("git", "ls-remote", remoteDir, "refs/tags/test/v0.1.0").Output()
+	require.NoError(t, err)
+	assert.Equal(t, strings.Split(string(tagOutput), "\t")[0], strings.Split(string(branchOutput), "\t")[0])
+}
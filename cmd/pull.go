@@ -0,0 +1,369 @@
+package cmd
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/cobra"
+)
+
+// resolveReference resolves a reference to a tag, a digest or a semantic
+// version constraint on the tags of a repository, e.g. repo:~1.4, to the
+// reference to pull. Tags are preferred over constraints, so a floating 1.4
+// tag wins over the highest 1.4.x version.
+func resolveReference(access *registryAccess, reference string) (name.Reference, error) {
+	// Split repository and constraint at the last colon outside the host
+	repository, constraint := reference, ""
+	if i := strings.LastIndex(reference, ":"); i > strings.LastIndex(reference, "/") && !strings.Contains(reference, "@") {
+		repository, constraint = reference[:i], reference[i+1:]
+	}
+	opts := access.nameOptions(repository)
+	ref, refErr := name.ParseReference(reference, opts...)
+	if _, ok := ref.(name.Digest); ok {
+		return ref, nil
+	}
+	if refErr == nil {
+		_, err := remote.Head(ref, access.remoteOpts...)
+		if err == nil || !isNotFound(err) || constraint == "" {
+			return ref, nil
+		}
+	}
+
+	versions, err := semver.NewConstraint(constraint)
+	if err != nil {
+		if refErr != nil {
+			return nil, fmt.Errorf("invalid reference %s, expected a tag, digest or version constraint: %v", reference, refErr)
+		}
+		return ref, nil
+	}
+	repo, err := name.NewRepository(repository, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository %s: %v", repository, err)
+	}
+	tags, err := remote.List(repo, access.remoteOpts...)
+	if err != nil {
+		return nil, access.error("list tags", repo.Registry, err)
+	}
+	var best *semver.Version
+	bestTag := ""
+	for _, tag := range tags {
+		version, err := semver.StrictNewVersion(strings.TrimPrefix(tag, "v"))
+		if err != nil || !versions.Check(version) {
+			continue
+		}
+		if best == nil || version.GreaterThan(best) {
+			best, bestTag = version, tag
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no version of %s matches %s", repository, constraint)
+	}
+	return repo.Tag(bestTag), nil
+}
+
+// unpackOptions configure unpacking layers.
+type unpackOptions struct {
+	// preservePermissions restores the modes and modification times of the
+	// entries instead of using the defaults
+	preservePermissions bool
+	warnings            io.Writer
+}
+
+// unpacker writes the entries of layers below a directory, refusing entries
+// that would end up outside of it.
+type unpacker struct {
+	dir  string
+	opts unpackOptions
+	// dirs are restored after their content is written
+	dirs []*tar.Header
+}
+
+// unpackPath returns where the slash-separated entry name is written.
+func (u *unpacker) unpackPath(name string) (string, error) {
+	clean := pathpkg.Clean(strings.TrimSuffix(name, "/"))
+	if pathpkg.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("refusing to unpack %s outside of the directory", name)
+	}
+	if clean == "." {
+		return u.dir, nil
+	}
+
+	// Never write through symlinks unpacked before
+	target := filepath.Join(u.dir, filepath.FromSlash(clean))
+	parent := u.dir
+	for _, segment := range strings.Split(pathpkg.Dir(clean), "/") {
+		if segment == "." {
+			break
+		}
+		parent = filepath.Join(parent, segment)
+		if info, err := os.Lstat(parent); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("refusing to unpack %s through symlink %s", name, strings.TrimPrefix(parent, u.dir+string(filepath.Separator)))
+		}
+	}
+	return target, nil
+}
+
+// unpack writes the entries of the uncompressed layer r.
+func (u *unpacker) unpack(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read layer: %v", err)
+		}
+		if err := u.unpackEntry(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// unpackEntry writes a single entry with the content read from r.
+func (u *unpacker) unpackEntry(header *tar.Header, r io.Reader) error {
+	target, err := u.unpackPath(header.Name)
+	if err != nil {
+		return err
+	}
+	if header.Typeflag != tar.TypeDir {
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %v", header.Name, err)
+		}
+		// Replace what is there instead of writing through it
+		if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+			if err := os.Remove(target); err != nil {
+				return fmt.Errorf("failed to replace %s: %v", header.Name, err)
+			}
+		}
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to unpack %s through symlink %s", header.Name, strings.TrimSuffix(header.Name, "/"))
+		}
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", header.Name, err)
+		}
+		u.dirs = append(u.dirs, header)
+		return nil
+
+	case tar.TypeReg:
+		mode := os.FileMode(0644)
+		if header.Mode&0111 != 0 {
+			mode = 0755
+		}
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %v", header.Name, err)
+		}
+		_, err = io.Copy(file, r)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %v", header.Name, err)
+		}
+
+	case tar.TypeSymlink:
+		resolved := pathpkg.Join(pathpkg.Dir(pathpkg.Clean(header.Name)), header.Linkname)
+		if pathpkg.IsAbs(header.Linkname) || resolved == ".." || strings.HasPrefix(resolved, "../") {
+			return fmt.Errorf("refusing to unpack symlink %s pointing outside of the directory to %s", header.Name, header.Linkname)
+		}
+		if err := os.Symlink(header.Linkname, target); err != nil {
+			return fmt.Errorf("failed to create symlink %s: %v", header.Name, err)
+		}
+		return nil
+
+	case tar.TypeLink:
+		source, err := u.unpackPath(header.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(source, target); err != nil {
+			return fmt.Errorf("failed to create hard link %s: %v", header.Name, err)
+		}
+		return nil
+
+	default:
+		fmt.Fprintf(u.opts.warnings, "Warning: skipping %s: unsupported entry type %q\n", header.Name, header.Typeflag)
+		return nil
+	}
+	return u.restore(target, header)
+}
+
+// restore applies the mode and modification time of header to target, if
+// permissions are preserved.
+func (u *unpacker) restore(target string, header *tar.Header) error {
+	if !u.opts.preservePermissions {
+		return nil
+	}
+	if err := os.Chmod(target, os.FileMode(header.Mode).Perm()); err != nil {
+		return fmt.Errorf("failed to restore mode of %s: %v", header.Name, err)
+	}
+	if err := os.Chtimes(target, time.Time{}, header.ModTime); err != nil {
+		return fmt.Errorf("failed to restore modification time of %s: %v", header.Name, err)
+	}
+	return nil
+}
+
+// finish restores the directories, deepest first, once nothing is written
+// to them anymore.
+func (u *unpacker) finish() error {
+	for i := len(u.dirs) - 1; i >= 0; i-- {
+		target, err := u.unpackPath(u.dirs[i].Name)
+		if err != nil {
+			return err
+		}
+		if err := u.restore(target, u.dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLayers calls read with the uncompressed content of every layer of img
+// in order. Layers are read to the end, so that their digests are verified.
+func readLayers(img v1.Image, read func(r io.Reader) error) error {
+	layers, err := img.Layers()
+	if err != nil {
+		return fmt.Errorf("failed to read layers: %v", err)
+	}
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return fmt.Errorf("failed to read layer digest: %v", err)
+		}
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return fmt.Errorf("failed to read layer %s: %v", digest, err)
+		}
+		err = read(rc)
+		if err == nil {
+			if _, err = io.Copy(io.Discard, rc); err != nil {
+				err = fmt.Errorf("failed to verify layer %s: %v", digest, err)
+			}
+		}
+		if closeErr := rc.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to verify layer %s: %v", digest, closeErr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listEntries prints the entries of the uncompressed layer r.
+func listEntries(w io.Writer, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read layer: %v", err)
+		}
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			fmt.Fprintf(w, "%s -> %s\n", header.Name, header.Linkname)
+		case tar.TypeLink:
+			fmt.Fprintf(w, "%s => %s\n", header.Name, header.Linkname)
+		default:
+			fmt.Fprintln(w, header.Name)
+		}
+	}
+}
+
+func NewOciPullCmd() *cobra.Command {
+	var registry registryFlags
+	var preservePermissions, list bool
+	cmd := &cobra.Command{
+		Use:   "pull [reference] [directory]",
+		Short: "Fetch a published directory and unpack it",
+		Long: `Fetch an image or artifact published with oci and unpack its layers into a
+directory.
+
+The reference is a tag (repo:1.4.2), a digest (repo@sha256:...) or a semantic
+version constraint on the tags of the repository, e.g. repo:~1.4 for the
+highest 1.4.x version. Existing tags win over constraints. The manifest and
+the layers are verified against their digests.
+
+Entries that would end up outside of the directory, through .. paths,
+absolute paths or symlinks, are refused. Files are written with default
+permissions, keeping them executable, unless --preserve-permissions restores
+the packaged modes and modification times. With --list, the files are printed
+instead of unpacked.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 2 && !list {
+				return fmt.Errorf("a directory to unpack into is required unless --list is set")
+			}
+
+			cfg, err := loadCommandConfig(cmd, "")
+			if err != nil {
+				return err
+			}
+			access, err := registry.access(cmd, cfg, args[0])
+			if err != nil {
+				return err
+			}
+			ref, err := resolveReference(access, args[0])
+			if err != nil {
+				return err
+			}
+			img, err := remote.Image(ref, access.remoteOpts...)
+			if err != nil {
+				return access.error("pull "+ref.String(), ref.Context().Registry, err)
+			}
+			digest, err := img.Digest()
+			if err != nil {
+				return fmt.Errorf("failed to get image digest: %v", err)
+			}
+			if expected, ok := ref.(name.Digest); ok && expected.DigestStr() != digest.String() {
+				return fmt.Errorf("digest mismatch for %s: got %s", ref, digest)
+			}
+
+			if list {
+				return readLayers(img, func(r io.Reader) error {
+					return listEntries(cmd.OutOrStdout(), r)
+				})
+			}
+
+			dir, err := filepath.Abs(args[1])
+			if err != nil {
+				return fmt.Errorf("failed to get absolute path: %v", err)
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %v", err)
+			}
+			u := &unpacker{dir: dir, opts: unpackOptions{preservePermissions: preservePermissions, warnings: cmd.ErrOrStderr()}}
+			if err := readLayers(img, u.unpack); err != nil {
+				return err
+			}
+			if err := u.finish(); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Pulled %s into %s\n", ref, args[1])
+			fmt.Fprintf(cmd.OutOrStdout(), "Digest: %s\n", digest)
+			return nil
+		},
+	}
+
+	addRegistryFlags(cmd, &registry)
+	cmd.Flags().BoolVar(&preservePermissions, "preserve-permissions", false, "Restore the packaged file modes and modification times")
+	cmd.Flags().BoolVar(&list, "list", false, "Print the packaged files instead of unpacking them")
+	return cmd
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnpacker(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tar.Header
+		wantErr string
+	}{
+		{
+			name:    "parent directory",
+			entries: []tar.Header{{Name: "../evil", Typeflag: tar.TypeReg}},
+			wantErr: "refusing to unpack ../evil outside of the directory",
+		},
+		{
+			name:    "nested parent directory",
+			entries: []tar.Header{{Name: "dir/../../evil", Typeflag: tar.TypeReg}},
+			wantErr: "outside of the directory",
+		},
+		{
+			name:    "absolute path",
+			entries: []tar.Header{{Name: "/evil", Typeflag: tar.TypeReg}},
+			wantErr: "outside of the directory",
+		},
+		{
+			name:    "absolute symlink",
+			entries: []tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}},
+			wantErr: "refusing to unpack symlink link pointing outside of the directory to /etc",
+		},
+		{
+			name:    "escaping symlink",
+			entries: []tar.Header{{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "../../outside"}},
+			wantErr: "pointing outside of the directory",
+		},
+		{
+			name: "file through symlink",
+			entries: []tar.Header{
+				{Name: "dir/", Typeflag: tar.TypeDir},
+				{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir"},
+				{Name: "link/file", Typeflag: tar.TypeReg},
+			},
+			wantErr: "refusing to unpack link/file through symlink link",
+		},
+		{
+			name: "directory through symlink",
+			entries: []tar.Header{
+				{Name: "dir/", Typeflag: tar.TypeDir},
+				{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir"},
+				{Name: "link/", Typeflag: tar.TypeDir},
+			},
+			wantErr: "through symlink link",
+		},
+		{
+			name:    "escaping hard link",
+			entries: []tar.Header{{Name: "link", Typeflag: tar.TypeLink, Linkname: "../outside"}},
+			wantErr: "outside of the directory",
+		},
+		{
+			name: "safe entries",
+			entries: []tar.Header{
+				{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+				{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0644},
+				{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "../dir/file"},
+				{Name: "hard", Typeflag: tar.TypeLink, Linkname: "dir/file"},
+				{Name: "./dir/./other", Typeflag: tar.TypeReg, Mode: 0644},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var layer bytes.Buffer
+			tw := tar.NewWriter(&layer)
+			for _, header := range tt.entries {
+				require.NoError(t, tw.WriteHeader(&header))
+			}
+			require.NoError(t, tw.Close())
+
+			root := t.TempDir()
+			dir := filepath.Join(root, "unpacked")
+			require.NoError(t, os.Mkdir(dir, 0755))
+			u := &unpacker{dir: dir, opts: unpackOptions{warnings: io.Discard}}
+			err := u.unpack(&layer)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				require.NoError(t, u.finish())
+				assert.FileExists(t, filepath.Join(dir, "dir", "other"))
+				assert.FileExists(t, filepath.Join(dir, "hard"))
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+
+			// Nothing was written next to the directory
+			entries, err := os.ReadDir(root)
+			require.NoError(t, err)
+			assert.Len(t, entries, 1)
+		})
+	}
+}
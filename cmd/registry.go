@@ -0,0 +1,359 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dockerconfig "github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	dockertypes "github.com/docker/cli/cli/config/types"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/spf13/cobra"
+)
+
+// registryTokenEnv holds a bearer token for the registry of the repository
+// argument.
+const registryTokenEnv = "RELEASE_TOOL_REGISTRY_TOKEN"
+
+// registryFlags are the flags of commands talking to registries.
+type registryFlags struct {
+	insecure      bool
+	username      string
+	passwordStdin bool
+	dockerConfig  string
+	caFile        string
+	certFile      string
+	keyFile       string
+}
+
+// addRegistryFlags adds the registry access flags to cmd.
+func addRegistryFlags(cmd *cobra.Command, f *registryFlags) {
+	cmd.Flags().BoolVar(&f.insecure, "insecure", false, "Allow pushing to insecure registries")
+	cmd.Flags().StringVar(&f.username, "username", "", "Username for the registry, with the password read from stdin (--password-stdin)")
+	cmd.Flags().BoolVar(&f.passwordStdin, "password-stdin", false, "Read the registry password from stdin")
+	cmd.Flags().StringVar(&f.dockerConfig, "docker-config", "", "Docker config.json to read registry credentials from instead of the default locations")
+	cmd.Flags().StringVar(&f.caFile, "ca-file", "", "PEM bundle of certificate authorities to trust in addition to the system ones")
+	cmd.Flags().StringVar(&f.certFile, "cert", "", "PEM client certificate to present to registries (requires --key)")
+	cmd.Flags().StringVar(&f.keyFile, "key", "", "PEM private key of the client certificate")
+}
+
+// registryAccess holds the options to reach registries.
+type registryAccess struct {
+	remoteOpts []remote.Option
+	transport  http.RoundTripper
+	keychain   *registryKeychain
+	// insecure allows plain HTTP to every registry, plainHTTP to some
+	insecure  bool
+	plainHTTP map[string]bool
+}
+
+// access returns the options to reach registries with the credentials of
+// the flags, the environment and the registries section of cfg. The
+// credentials of the flags and the environment are only sent to the registry
+// of reference, the repository argument of the command.
+func (f *registryFlags) access(cmd *cobra.Command, cfg *config, reference string) (*registryAccess, error) {
+	keychain := &registryKeychain{registry: registryOf(reference), registries: cfg.Registries, token: os.Getenv(registryTokenEnv)}
+	if f.passwordStdin != (f.username != "") {
+		return nil, fmt.Errorf("--username and --password-stdin must be used together")
+	}
+	if f.passwordStdin {
+		password, err := io.ReadAll(cmd.InOrStdin())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password from stdin: %v", err)
+		}
+		keychain.basic = &authn.Basic{Username: f.username, Password: strings.TrimRight(string(password), "\r\n")}
+	}
+	if f.dockerConfig != "" {
+		file, err := os.Open(f.dockerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open docker config: %v", err)
+		}
+		defer file.Close()
+		if keychain.dockerConfig, err = dockerconfig.LoadFromReader(file); err != nil {
+			return nil, fmt.Errorf("failed to parse docker config %s: %v", f.dockerConfig, err)
+		}
+	}
+
+	if (f.certFile == "") != (f.keyFile == "") {
+		return nil, fmt.Errorf("--cert and --key must be used together")
+	}
+	defaults := tlsSettings{certFile: f.certFile, keyFile: f.keyFile, insecure: f.insecure}
+	if f.caFile != "" {
+		defaults.caFiles = []string{f.caFile}
+	}
+	base, err := defaults.transport()
+	if err != nil {
+		return nil, err
+	}
+	transport := &registryTransport{base: base, hosts: map[string]http.RoundTripper{}}
+	access := &registryAccess{transport: transport, keychain: keychain, insecure: f.insecure, plainHTTP: map[string]bool{}}
+	for host, registry := range cfg.Registries {
+		if registry.PlainHTTP {
+			access.plainHTTP[host] = true
+		}
+		if registry.CAFile == "" && registry.CertFile == "" && registry.KeyFile == "" && !registry.Insecure {
+			continue
+		}
+		if (registry.CertFile == "") != (registry.KeyFile == "") {
+			return nil, fmt.Errorf("registry %s: certFile and keyFile must be set together", host)
+		}
+		settings := defaults
+		if registry.CAFile != "" {
+			settings.caFiles = append(append([]string{}, defaults.caFiles...), registry.CAFile)
+		}
+		if registry.CertFile != "" {
+			settings.certFile, settings.keyFile = registry.CertFile, registry.KeyFile
+		}
+		settings.insecure = settings.insecure || registry.Insecure
+		if transport.hosts[host], err = settings.transport(); err != nil {
+			return nil, fmt.Errorf("registry %s: %v", host, err)
+		}
+	}
+	// Transient statuses are retried by retryTransport, which honours
+	// Retry-After, rather than by go-containerregistry
+	access.remoteOpts = []remote.Option{
+		remote.WithContext(cmd.Context()),
+		remote.WithAuthFromKeychain(keychain),
+		remote.WithTransport(&retryTransport{base: access.transport, policy: networkPolicyFrom(cmd.Context())}),
+		remote.WithRetryStatusCodes(),
+	}
+	return access, nil
+}
+
+// registryOf returns the registry of reference, e.g. ghcr.io:443, or an empty
+// string if reference is invalid.
+func registryOf(reference string) string {
+	ref, err := name.ParseReference(reference)
+	if err != nil {
+		return ""
+	}
+	return ref.Context().RegistryStr()
+}
+
+// nameOptions returns the options to parse references to the registry of
+// reference with.
+func (a *registryAccess) nameOptions(reference string) []name.Option {
+	if a.insecure {
+		return []name.Option{name.Insecure}
+	}
+	// Invalid references are reported when parsed with the options
+	if ref, err := name.ParseReference(reference); err == nil && a.plainHTTP[ref.Context().RegistryStr()] {
+		return []name.Option{name.Insecure}
+	}
+	return nil
+}
+
+// registryConfig configures access to a registry. Secrets are not stored in
+// the configuration file, only the names of the environment variables
+// holding them.
+type registryConfig struct {
+	// Username authenticates with the password in PasswordEnv.
+	Username    string `yaml:"username"`
+	PasswordEnv string `yaml:"passwordEnv"`
+	// TokenEnv holds a bearer token instead.
+	TokenEnv string `yaml:"tokenEnv"`
+	// CAFile is a PEM bundle of certificate authorities to trust in addition
+	// to --ca-file. CertFile and KeyFile hold a client certificate, replacing
+	// --cert and --key. Relative paths are relative to the configuration file.
+	CAFile   string `yaml:"caFile"`
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// Insecure skips verifying the certificate of the registry.
+	Insecure bool `yaml:"insecure"`
+	// PlainHTTP talks to the registry over HTTP instead of HTTPS.
+	PlainHTTP bool `yaml:"plainHTTP"`
+}
+
+// resolvePaths makes the relative paths of the configuration relative to dir.
+func (c *registryConfig) resolvePaths(dir string) {
+	for _, path := range []*string{&c.CAFile, &c.CertFile, &c.KeyFile} {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+}
+
+// tlsSettings configure the TLS connections to a registry.
+type tlsSettings struct {
+	caFiles  []string
+	certFile string
+	keyFile  string
+	insecure bool
+}
+
+// transport returns a transport connecting with the settings.
+func (s tlsSettings) transport() (*http.Transport, error) {
+	config := &tls.Config{InsecureSkipVerify: s.insecure}
+	if len(s.caFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range s.caFiles {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA file: %v", err)
+			}
+			if !pool.AppendCertsFromPEM(content) {
+				return nil, fmt.Errorf("no PEM certificates found in CA file %s", file)
+			}
+		}
+		config.RootCAs = pool
+	}
+	if s.certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport := remote.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport, nil
+}
+
+// registryTransport sends the requests to registries with their own TLS
+// settings through their transport, and all others through base.
+type registryTransport struct {
+	base  http.RoundTripper
+	hosts map[string]http.RoundTripper
+}
+
+func (t *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if transport, ok := t.hosts[req.URL.Host]; ok {
+		return transport.RoundTrip(req)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// registryKeychain resolves credentials from, in order: --username and
+// --password-stdin, registryTokenEnv, the registries of the project
+// configuration, --docker-config and finally the default Docker keychain.
+// The first two only apply to registry, so that they are not leaked to other
+// registries the command talks to.
+type registryKeychain struct {
+	registry     string
+	basic        *authn.Basic
+	token        string
+	registries   map[string]registryConfig
+	dockerConfig *configfile.ConfigFile
+}
+
+// explicit reports whether credentials were given with the flags or the
+// environment.
+func (k *registryKeychain) explicit() bool {
+	return k.basic != nil || k.token != ""
+}
+
+func (k *registryKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if k.registry != "" && target.RegistryStr() == k.registry {
+		if k.basic != nil {
+			return k.basic, nil
+		}
+		if k.token != "" {
+			return &authn.Bearer{Token: k.token}, nil
+		}
+	}
+
+	if registry, ok := k.registries[target.RegistryStr()]; ok {
+		switch {
+		case registry.TokenEnv != "":
+			token := os.Getenv(registry.TokenEnv)
+			if token == "" {
+				return nil, fmt.Errorf("environment variable %s with the token for %s is not set", registry.TokenEnv, target.RegistryStr())
+			}
+			return &authn.Bearer{Token: token}, nil
+		case registry.Username != "":
+			password := os.Getenv(registry.PasswordEnv)
+			if registry.PasswordEnv == "" || password == "" {
+				return nil, fmt.Errorf("environment variable %q with the password for %s is not set", registry.PasswordEnv, target.RegistryStr())
+			}
+			return &authn.Basic{Username: registry.Username, Password: password}, nil
+		}
+	}
+
+	if k.dockerConfig != nil {
+		for _, key := range []string{target.String(), target.RegistryStr()} {
+			if key == name.DefaultRegistry {
+				key = authn.DefaultAuthKey
+			}
+			auth, err := k.dockerConfig.GetAuthConfig(key)
+			if err != nil {
+				return nil, err
+			}
+			auth.ServerAddress = ""
+			if auth != (dockertypes.AuthConfig{}) {
+				return authn.FromConfig(authn.AuthConfig{
+					Username:      auth.Username,
+					Password:      auth.Password,
+					Auth:          auth.Auth,
+					IdentityToken: auth.IdentityToken,
+					RegistryToken: auth.RegistryToken,
+				}), nil
+			}
+		}
+		return authn.Anonymous, nil
+	}
+	return authn.DefaultKeychain.Resolve(target)
+}
+
+// error describes a failed registry operation. Authentication and
+// authorization failures name the status and the realm the registry asks to
+// authenticate with.
+func (a *registryAccess) error(action string, registry name.Registry, err error) error {
+	var transportErr *transport.Error
+	if !errors.As(err, &transportErr) {
+		return fmt.Errorf("failed to %s: %v", action, err)
+	}
+	var problem string
+	switch transportErr.StatusCode {
+	case http.StatusUnauthorized:
+		problem = "authentication failed"
+	case http.StatusForbidden:
+		problem = "access denied"
+	default:
+		return fmt.Errorf("failed to %s: %v", action, err)
+	}
+
+	detail := fmt.Sprintf("%d %s", transportErr.StatusCode, http.StatusText(transportErr.StatusCode))
+	if realm := a.realm(registry); realm != "" {
+		detail += fmt.Sprintf(", realm %q", realm)
+	}
+	return fmt.Errorf("failed to %s: %s for registry %s (%s): %v", action, problem, registry.RegistryStr(), detail, err)
+}
+
+// realmTimeout bounds looking up the realm for an error message.
+const realmTimeout = 10 * time.Second
+
+// realm returns the realm of the authentication challenge of registry, if
+// any.
+func (a *registryAccess) realm(registry name.Registry) string {
+	client := &http.Client{Transport: a.transport, Timeout: realmTimeout}
+	resp, err := client.Get(registry.Scheme() + "://" + registry.RegistryStr() + "/v2/")
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+	for _, challenge := range resp.Header.Values("WWW-Authenticate") {
+		_, params, _ := strings.Cut(challenge, " ")
+		for _, param := range strings.Split(params, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(key, "realm") {
+				return strings.Trim(value, `"`)
+			}
+		}
+	}
+	return ""
+}
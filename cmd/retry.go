@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// networkPolicy bounds and retries operations against registries and git
+// remotes.
+type networkPolicy struct {
+	// timeout bounds every attempt of an operation, zero for no bound
+	timeout time.Duration
+	// retries is how often a transient failure is retried
+	retries int
+	// delay is the first backoff delay, doubled on every retry
+	delay time.Duration
+}
+
+// defaultNetworkPolicy applies unless the command configures one.
+var defaultNetworkPolicy = networkPolicy{timeout: 10 * time.Minute, retries: 3, delay: time.Second}
+
+// maxRetryDelay caps the exponential backoff.
+const maxRetryDelay = 30 * time.Second
+
+type networkPolicyKey struct{}
+
+// addNetworkFlags adds the flags configuring the network policy to cmd.
+func addNetworkFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().Duration("timeout", defaultNetworkPolicy.timeout, "Timeout of every attempt of a registry request or git remote operation, 0 for none")
+	cmd.PersistentFlags().Int("retries", defaultNetworkPolicy.retries, "Number of retries of transient registry and git remote failures")
+}
+
+// withNetworkPolicy returns ctx carrying the network policy configured by the
+// flags of cmd.
+func withNetworkPolicy(ctx context.Context, cmd *cobra.Command) (context.Context, error) {
+	policy := defaultNetworkPolicy
+	if flag := cmd.Flag("timeout"); flag != nil {
+		timeout, err := time.ParseDuration(flag.Value.String())
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid --timeout %s", flag.Value)
+		}
+		policy.timeout = timeout
+	}
+	if flag := cmd.Flag("retries"); flag != nil {
+		retries, err := strconv.Atoi(flag.Value.String())
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("invalid --retries %s", flag.Value)
+		}
+		policy.retries = retries
+	}
+	return context.WithValue(ctx, networkPolicyKey{}, policy), nil
+}
+
+// networkPolicyFrom returns the network policy of ctx.
+func networkPolicyFrom(ctx context.Context) networkPolicy {
+	if policy, ok := ctx.Value(networkPolicyKey{}).(networkPolicy); ok {
+		return policy
+	}
+	return defaultNetworkPolicy
+}
+
+// attemptContext returns the context of a single attempt.
+func (p networkPolicy) attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, p.timeout)
+}
+
+// backoff returns the delay before the retry following attempt, counted
+// from 0.
+func (p networkPolicy) backoff(attempt int) time.Duration {
+	delay := p.delay
+	for i := 0; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryTransport retries registry requests failing with a transient status
+// or timing out, honouring Retry-After. Requests with a body that cannot be
+// replayed are sent once.
+type retryTransport struct {
+	base   http.RoundTripper
+	policy networkPolicy
+}
+
+// transientStatus reports whether a response status is worth retrying.
+func transientStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := t.policy.attemptContext(req.Context())
+		attemptReq := req.WithContext(ctx)
+		if attempt > 0 && req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		retry := replayable && attempt < t.policy.retries && req.Context().Err() == nil
+		var delay time.Duration
+		switch {
+		case err != nil:
+			cancel()
+			// Only attempts running out of time are retried here, network
+			// errors are retried by go-containerregistry
+			if !retry || !errors.Is(err, context.DeadlineExceeded) {
+				return nil, err
+			}
+			delay = t.policy.backoff(attempt)
+		case retry && transientStatus(resp.StatusCode):
+			delay = retryAfter(resp.Header.Get("Retry-After"), t.policy.backoff(attempt))
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			cancel()
+		default:
+			resp.Body = &cancelReader{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		if err := sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryAfter returns the delay a Retry-After header asks for, in seconds or
+// as an HTTP date, or fallback without one.
+func retryAfter(header string, fallback time.Duration) time.Duration {
+	if header == "" {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return fallback
+}
+
+// cancelReader cancels the context of a request when its response body is
+// closed.
+type cancelReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+// transientGitErrors are messages of git failures worth retrying: network
+// trouble, overloaded servers and refs locked by concurrent pushes.
+var transientGitErrors = []string{
+	"could not resolve host",
+	"temporary failure in name resolution",
+	"connection timed out",
+	"connection reset",
+	"connection refused",
+	"operation timed out",
+	"the remote end hung up unexpectedly",
+	"early eof",
+	"rpc failed",
+	"returned error: 429",
+	"returned error: 5",
+	"cannot lock ref",
+}
+
+// isTransientGitError reports whether a failed git command is worth retrying.
+func isTransientGitError(err error) bool {
+	message := strings.ToLower(err.Error())
+	for _, transient := range transientGitErrors {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// runGitRemote runs a git command talking to a remote, like runGit, bounding
+// every attempt by the timeout of the network policy of ctx and retrying
+// transient failures.
+func runGitRemote(ctx context.Context, dir string, args ...string) (string, error) {
+	policy := networkPolicyFrom(ctx)
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := policy.attemptContext(ctx)
+		output, err := runGitContext(attemptCtx, dir, args...)
+		timedOut := errors.Is(attemptCtx.Err(), context.DeadlineExceeded)
+		cancel()
+		switch {
+		case err == nil:
+			return output, nil
+		case ctx.Err() != nil:
+			return "", ctx.Err()
+		case timedOut:
+			err = fmt.Errorf("git %s timed out after %s", args[0], policy.timeout)
+		}
+		if attempt == policy.retries || !(timedOut || isTransientGitError(err)) {
+			return "", err
+		}
+		if err := sleep(ctx, policy.backoff(attempt)); err != nil {
+			return "", err
+		}
+	}
+}
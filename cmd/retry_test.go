@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkPolicy(t *testing.T) {
+	policy := networkPolicy{delay: time.Second}
+	assert.Equal(t, time.Second, policy.backoff(0))
+	assert.Equal(t, 4*time.Second, policy.backoff(2))
+	assert.Equal(t, maxRetryDelay, policy.backoff(10))
+	assert.Equal(t, maxRetryDelay, policy.backoff(100))
+
+	assert.Equal(t, 3*time.Second, retryAfter("", 3*time.Second))
+	assert.Equal(t, 5*time.Second, retryAfter("5", time.Second))
+	assert.Equal(t, time.Second, retryAfter("soon", time.Second))
+	assert.Equal(t, time.Duration(0), retryAfter(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), time.Second))
+	assert.InDelta(t, float64(time.Minute), float64(retryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), time.Second)), float64(2*time.Second))
+
+	assert.True(t, isTransientGitError(errors.New("fatal: unable to access 'https://example.com/repo.git/': The requested URL returned error: 503")))
+	assert.True(t, isTransientGitError(errors.New("fatal: the remote end hung up unexpectedly")))
+	assert.True(t, isTransientGitError(errors.New("error: cannot lock ref 'refs/notes/releases'")))
+	assert.False(t, isTransientGitError(errors.New("fatal: Authentication failed for 'https://example.com/repo.git/'")))
+	assert.False(t, isTransientGitError(errors.New("! [rejected] main -> main (non-fast-forward)")))
+}
+
+func TestRunGitRemoteCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := runGitRemote(ctx, t.TempDir(), "ls-remote", "https://example.invalid/repo.git")
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -1,6 +1,11 @@
 package cmd
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/spf13/cobra"
 )
 
@@ -9,14 +14,30 @@ func NewRootCmd() *cobra.Command {
 		Use:   "release-tool",
 		Short: "A tool for managing releases",
 		Long:  `A command line tool for managing releases with semantic versioning.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := withNetworkPolicy(cmd.Context(), cmd)
+			if err != nil {
+				return err
+			}
+			cmd.SetContext(ctx)
+			return nil
+		},
 	}
 
+	rootCmd.PersistentFlags().String("config", "", "Path to the project config file (default is "+configFileName+" at the repository root)")
+	addNetworkFlags(rootCmd)
+
 	rootCmd.AddCommand(NewPublishCmd())
 	rootCmd.AddCommand(NewOciCmd())
 	rootCmd.AddCommand(NewVersionCmd())
+	rootCmd.AddCommand(NewShowCmd())
 	return rootCmd
 }
 
+// Execute runs the command line, stopping running operations on SIGINT or
+// SIGTERM.
 func Execute() error {
-	return NewRootCmd().Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return NewRootCmd().ExecuteContext(ctx)
 }
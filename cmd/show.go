@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func NewShowCmd() *cobra.Command {
+	var fetch, jsonOutput bool
+	cmd := &cobra.Command{
+		Use:   "show [name] [version]",
+		Short: "Show the record of a published release",
+		Long:  `Show who published a release, when, from which pipeline and with which images, as recorded in ` + releaseNotesRef + `.`,
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			version := strings.TrimPrefix(args[1], "v")
+
+			// Get the latest notes from origin
+			if _, err := runGit("", "remote", "get-url", "origin"); fetch && err == nil {
+				if err := fetchReleaseNotes(cmd.Context(), ""); err != nil {
+					return err
+				}
+			}
+
+			commit, err := resolveCommit("", name+"/v"+version)
+			if err != nil {
+				return fmt.Errorf("release %s %s not found: %v", name, version, err)
+			}
+			records, err := readReleaseRecords("", commit)
+			if err != nil {
+				return err
+			}
+			record := findReleaseRecord(records, name, version)
+			if record == nil {
+				return fmt.Errorf("no release record for %s %s in %s", name, version, releaseNotesRef)
+			}
+
+			out := cmd.OutOrStdout()
+			if jsonOutput {
+				content, err := json.MarshalIndent(record, "", "  ")
+				if err != nil {
+					return fmt.Errorf("failed to encode release record: %v", err)
+				}
+				fmt.Fprintln(out, string(content))
+				return nil
+			}
+
+			fmt.Fprintf(out, "Release:  %s %s\n", record.Name, record.Version)
+			fmt.Fprintf(out, "Tag:      %s\n", record.Tag)
+			fmt.Fprintf(out, "Commit:   %s\n", record.Commit)
+			fmt.Fprintf(out, "Released: %s", record.Timestamp.Format(time.RFC3339))
+			if record.Releaser != "" {
+				fmt.Fprintf(out, " by %s", record.Releaser)
+			}
+			fmt.Fprintln(out)
+			if record.CIURL != "" {
+				fmt.Fprintf(out, "CI:       %s\n", record.CIURL)
+			}
+			if len(record.Images) > 0 {
+				fmt.Fprintln(out, "Images:")
+				for _, image := range record.Images {
+					fmt.Fprintf(out, "  %s@%s\n", image.Reference, image.Digest)
+				}
+			}
+			if len(record.Changelog) > 0 {
+				fmt.Fprintln(out, "Changelog:")
+				for _, line := range record.Changelog {
+					fmt.Fprintf(out, "  %s\n", line)
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&fetch, "fetch", true, "Fetch release records from origin first")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the release record as JSON")
+	return cmd
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/kuberik/release-tool/cmd/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShowCmd(t *testing.T) {
+	// Start a local registry
+	registry := testhelpers.LocalRegistry()
+	defer registry.Close()
+
+	// Setup test repository
+	localDir, remoteDir := setupTestRepo(t)
+
+	// Change to test directory
+	oldDir, err := os.Getwd()
+	require.NoError(t, err)
+	defer os.Chdir(oldDir)
+	require.NoError(t, os.Chdir(localDir))
+
+	t.Setenv("GITHUB_ACTOR", "octocat")
+	t.Setenv("GITHUB_SERVER_URL", "https://github.com")
+	t.Setenv("GITHUB_REPOSITORY", "kuberik/example")
+	t.Setenv("GITHUB_RUN_ID", "42")
+
+	// Publish a release and an image for it
+	output, err := executeCommand(NewRootCmd(), "publish", "test")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Recorded release in refs/notes/releases")
+
+	imageName := strings.TrimPrefix(registry.URL, "http://") + "/test/image:latest"
+	_, err = executeCommand(NewRootCmd(), "oci", "test", imageName, localDir)
+	require.NoError(t, err)
+	digest, err := crane.Digest(imageName)
+	require.NoError(t, err)
+
+	// The notes were pushed along with the release
+	_, err = exec.Command("git", "--git-dir", remoteDir, "rev-parse", "--verify", releaseNotesRef).Output()
+	require.NoError(t, err)
+
+	output, err = executeCommand(NewRootCmd(), "show", "test", "0.1.0")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Release:  test 0.1.0")
+	assert.Contains(t, output, "Tag:      test/v0.1.0")
+	assert.Contains(t, output, "by octocat")
+	assert.Contains(t, output, "CI:       https://github.com/kuberik/example/actions/runs/42")
+	assert.Contains(t, output, imageName+"@"+digest)
+	assert.Contains(t, output, "Add newfile2.txt")
+
+	output, err = executeCommand(NewRootCmd(), "show", "test", "v0.1.0", "--json")
+	require.NoError(t, err)
+	var record releaseRecord
+	require.NoError(t, json.Unmarshal([]byte(output), &record))
+	assert.Equal(t, "test/v0.1.0", record.Tag)
+	require.Len(t, record.Images, 2)
+	assert.Equal(t, digest, record.Images[0].Digest)
+
+	_, err = executeCommand(NewRootCmd(), "show", "test", "0.2.0")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "release test 0.2.0 not found")
+}
+
+func TestShowCmdMergesReleaseNotes(t *testing.T) {
+	// Setup test repository
+	localDir, remoteDir := setupTestRepo(t)
+
+	// Change to test directory
+	oldDir, err := os.Getwd()
+	require.NoError(t, err)
+	defer os.Chdir(oldDir)
+	require.NoError(t, os.Chdir(localDir))
+
+	_, err = executeCommand(NewRootCmd(), "publish", "test")
+	require.NoError(t, err)
+
+	git := func(dir string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+	note := func(name string, version string) string {
+		content, err := json.Marshal(releaseNote{Releases: []releaseRecord{{Name: name, Version: version, Tag: name + "/v" + version}}})
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	// Another release was recorded on origin in the meantime
+	remoteCommit, err := resolveCommit("", "HEAD~1")
+	require.NoError(t, err)
+	git(remoteDir, "-c", "user.name=Other User", "-c", "user.email=other@example.com", "notes", "--ref", releaseNotesRef, "add", "-m", note("remote", "1.0.0"), remoteCommit)
+
+	// A local record that was never pushed
+	localCommit, err := resolveCommit("", "HEAD~2")
+	require.NoError(t, err)
+	git(localDir, "tag", "local/v1.0.0", localCommit)
+	git(localDir, "notes", "--ref", releaseNotesRef, "add", "-m", note("local", "1.0.0"), localCommit)
+
+	// Both survive the fetch
+	output, err := executeCommand(NewRootCmd(), "show", "local", "1.0.0")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Release:  local 1.0.0")
+	records, err := readReleaseRecords("", remoteCommit)
+	require.NoError(t, err)
+	assert.NotNil(t, findReleaseRecord(records, "remote", "1.0.0"))
+
+	// Failing to reach origin is reported
+	git(localDir, "remote", "set-url", "origin", filepath.Join(t.TempDir(), "missing"))
+	_, err = executeCommand(NewRootCmd(), "show", "local", "1.0.0")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to fetch release notes")
+}
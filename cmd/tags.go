@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// defaultTagTemplates are the tags pushed when neither the image reference
+// nor --tag specify any.
+var defaultTagTemplates = []string{"latest", "{{.Version}}"}
+
+// tagTemplateData returns the values available to --tag templates. The
+// semantic version parts are only set when version is a semantic version, so
+// that templates using them fail for commit hash versions.
+func tagTemplateData(releaseName string, version string) map[string]string {
+	data := map[string]string{
+		"Name":    releaseName,
+		"Version": version,
+	}
+	if v, err := semver.StrictNewVersion(version); err == nil {
+		data["Major"] = fmt.Sprint(v.Major())
+		data["Minor"] = fmt.Sprint(v.Minor())
+		data["Patch"] = fmt.Sprint(v.Patch())
+		data["Prerelease"] = v.Prerelease()
+	}
+	return data
+}
+
+// resolveImageTags returns the tag references to push for imageName. The
+// image may name a repository, in which case tagTemplates or the defaults
+// apply, or a single tag, which is pushed alongside the version. Digest
+// references cannot be pushed to. extraTemplates are always added. Every
+// rendered tag is validated.
+//
+// The tags rendered from a template using {{.Version}}, such as
+// v{{.Version}}, name the published version and are returned as a set of
+// tag strings as well.
+func resolveImageTags(imageName string, tagTemplates []string, extraTemplates []string, data map[string]string, opts ...name.Option) ([]name.Tag, map[string]bool, error) {
+	ref, err := name.ParseReference(imageName, opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse image reference: %v", err)
+	}
+
+	switch ref := ref.(type) {
+	case name.Digest:
+		return nil, nil, fmt.Errorf("cannot publish to digest reference %s, pass a repository and --tag", imageName)
+	case name.Tag:
+		explicit := strings.HasSuffix(imageName, ":"+ref.TagStr())
+		switch {
+		case explicit && len(tagTemplates) > 0:
+			return nil, nil, fmt.Errorf("image reference %s already has a tag, pass a repository when using --tag", imageName)
+		case explicit:
+			tagTemplates = []string{ref.TagStr(), "{{.Version}}"}
+		case len(tagTemplates) == 0:
+			tagTemplates = defaultTagTemplates
+		}
+	}
+
+	repository := ref.Context()
+	var tags []name.Tag
+	versionTags := map[string]bool{}
+	seen := map[string]bool{}
+	for _, tagTemplate := range append(append([]string{}, tagTemplates...), extraTemplates...) {
+		tag, err := renderTemplate("tag", tagTemplate, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if rendersVersion(tagTemplate, data, tag) {
+			versionTags[tag] = true
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+
+		tagRef, err := name.NewTag(repository.String()+":"+tag, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid tag %q rendered from %q: %v", tag, tagTemplate, err)
+		}
+		tags = append(tags, tagRef)
+	}
+	return tags, versionTags, nil
+}
+
+// rendersVersion reports whether tagTemplate, which rendered tag from data,
+// depends on {{.Version}}: rendering it with a different version must change
+// the result.
+func rendersVersion(tagTemplate string, data map[string]string, tag string) bool {
+	probe := make(map[string]string, len(data))
+	for key, value := range data {
+		probe[key] = value
+	}
+	probe["Version"] = data["Version"] + "-probe"
+	rendered, err := renderTemplate("tag", tagTemplate, probe)
+	return err == nil && rendered != tag
+}
+
+// floatingTagTemplates are the tags added by --floating-tags.
+var floatingTagTemplates = []string{"{{.Major}}", "{{.Major}}.{{.Minor}}"}
+
+// selectFloatingTags drops the floating tags that would move backwards.
+// Floating tags are latest, X and X.Y for version X.Y.Z; each is only moved
+// if version is the highest release among the existing tags in its scope
+// (all versions, the same major or the same minor respectively). Prerelease
+// versions never move floating tags. Other tags, and all tags of versions
+// that are not semantic versions, are kept. The dropped tags are returned
+// with the reason they were skipped.
+func selectFloatingTags(tags []name.Tag, version string, existing []string) ([]name.Tag, []string) {
+	v, err := semver.StrictNewVersion(version)
+	if err != nil {
+		return tags, nil
+	}
+
+	var existingVersions []*semver.Version
+	for _, tag := range existing {
+		if ev, err := semver.StrictNewVersion(tag); err == nil && ev.Prerelease() == "" {
+			existingVersions = append(existingVersions, ev)
+		}
+	}
+
+	var kept []name.Tag
+	var skipped []string
+	for _, tag := range tags {
+		var inScope func(ev *semver.Version) bool
+		switch tag.TagStr() {
+		case "latest":
+			inScope = func(ev *semver.Version) bool { return true }
+		case fmt.Sprint(v.Major()):
+			inScope = func(ev *semver.Version) bool { return ev.Major() == v.Major() }
+		case fmt.Sprintf("%d.%d", v.Major(), v.Minor()):
+			inScope = func(ev *semver.Version) bool { return ev.Major() == v.Major() && ev.Minor() == v.Minor() }
+		default:
+			kept = append(kept, tag)
+			continue
+		}
+
+		if v.Prerelease() != "" {
+			skipped = append(skipped, fmt.Sprintf("%s (%s is a prerelease)", tag.TagStr(), version))
+			continue
+		}
+		var newer *semver.Version
+		for _, ev := range existingVersions {
+			if inScope(ev) && ev.GreaterThan(v) && (newer == nil || ev.GreaterThan(newer)) {
+				newer = ev
+			}
+		}
+		if newer != nil {
+			skipped = append(skipped, fmt.Sprintf("%s (%s is newer)", tag.TagStr(), newer))
+			continue
+		}
+		kept = append(kept, tag)
+	}
+	return kept, skipped
+}
@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// renderTemplate executes a Go text/template against data. References to
+// missing keys are errors rather than "<no value>".
+func renderTemplate(name string, text string, data any) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s template: %v", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %v", name, err)
+	}
+	return buf.String(), nil
+}
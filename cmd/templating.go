@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// templateContext holds the values substituted in packaged files, either as
+// $(name) placeholders or, with goTemplate, by rendering the files as Go
+// templates.
+//
+// The placeholders are name, version, major, minor, patch, prerelease,
+// commit, date, values.<key> for --set values, and digest:<component> and
+// image:<component> (repository@digest) for the image published for the
+// release of another component at the same ref. Go templates see the same
+// values as .Name, .Version, .Major, .Minor, .Patch, .Prerelease, .Commit,
+// .Date and .Values.<key>, and the images through the digest and image
+// functions.
+//
+// Placeholders are substituted while streaming, but a Go template has to be
+// parsed whole, so Go template files and their output are held in memory and
+// limited to maxGoTemplateSize.
+type templateContext struct {
+	ctx        context.Context
+	dir        string
+	ref        string
+	goTemplate bool
+	// fetchNotes is set if the release notes are yet to be fetched from
+	// origin before looking up images
+	fetchNotes bool
+	// values holds the placeholder values
+	values map[string]string
+	// data holds the Go template values
+	data map[string]any
+
+	mu sync.Mutex
+	// images caches the published images of other components
+	images map[string]releaseImage
+}
+
+// newTemplateContext returns the template values for releaseName published
+// from source at ref in dir. modTime is the release date; setValues are the
+// user-supplied key=value pairs. With fetch, the release notes recording the
+// images of other components are fetched from origin on first use.
+func newTemplateContext(ctx context.Context, dir string, ref string, releaseName string, source releaseSource, modTime time.Time, setValues []string, goTemplate bool, fetch bool) (*templateContext, error) {
+	c := &templateContext{
+		ctx:        ctx,
+		dir:        dir,
+		ref:        ref,
+		goTemplate: goTemplate,
+		fetchNotes: fetch,
+		values:     map[string]string{},
+		data:       map[string]any{},
+		images:     map[string]releaseImage{},
+	}
+	for key, value := range tagTemplateData(releaseName, source.version) {
+		c.values[strings.ToLower(key)] = value
+		c.data[key] = value
+	}
+	c.values["date"] = modTime.Format(time.DateOnly)
+	c.data["Date"] = c.values["date"]
+	if source.commit != "" {
+		c.values["commit"] = source.commit
+		c.data["Commit"] = source.commit
+	}
+
+	values := map[string]string{}
+	for _, setValue := range setValues {
+		key, value, ok := strings.Cut(setValue, "=")
+		if !ok || key == "" || strings.IndexFunc(key, func(r rune) bool { return r > 0x7f || !isPlaceholderNameByte(byte(r)) }) >= 0 {
+			return nil, fmt.Errorf("invalid --set value %q, expected key=value with a key of letters, digits and \"_.:-\"", setValue)
+		}
+		values[key] = value
+		c.values["values."+key] = value
+	}
+	c.data["Values"] = values
+	return c, nil
+}
+
+// maxGoTemplateSize is the largest file rendered as a Go template, and the
+// largest output it may render, in bytes.
+var maxGoTemplateSize int64 = 16 << 20
+
+// expand returns the content of r with the template values substituted. The
+// returned counts of substitutions per placeholder are complete once the
+// content has been read; Go templates do not count substitutions.
+//
+// In files picked explicitly for templating, every $(name) is a placeholder.
+// Other text files may hold shell or Makefile syntax, so only the names of
+// template values are substituted there and the remaining $(...) text and
+// "$$(" are kept as they are.
+func (c *templateContext) expand(name string, r io.Reader, explicit bool) (io.Reader, map[string]int, error) {
+	if !c.goTemplate {
+		placeholders := newPlaceholderReader(r, c.lookup)
+		if !explicit {
+			placeholders.known = isTemplateValueName
+		}
+		return placeholders, placeholders.counts, nil
+	}
+
+	text, err := io.ReadAll(io.LimitReader(r, maxGoTemplateSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(text)) > maxGoTemplateSize {
+		return nil, nil, fmt.Errorf("file exceeds the Go template size limit of %d bytes, leave it out with --template-include", maxGoTemplateSize)
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"digest": func(component string) (string, error) {
+			image, err := c.image(component)
+			return image.Digest, err
+		},
+		"image": func(component string) (string, error) {
+			image, err := c.image(component)
+			return image.Reference + "@" + image.Digest, err
+		},
+	}).Parse(string(text))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse template: %v", err)
+	}
+	buf := &cappedBuffer{limit: maxGoTemplateSize}
+	if err := tmpl.Execute(buf, c.data); err != nil {
+		return nil, nil, fmt.Errorf("failed to render template: %v", err)
+	}
+	return &buf.Buffer, nil, nil
+}
+
+// cappedBuffer is a bytes.Buffer refusing to grow beyond limit bytes.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if int64(b.Len()+len(p)) > b.limit {
+		return 0, fmt.Errorf("output exceeds the Go template size limit of %d bytes", b.limit)
+	}
+	return b.Buffer.Write(p)
+}
+
+// isTemplateValueName reports whether name is one of the placeholders of a
+// templateContext, whether or not it has a value.
+func isTemplateValueName(name string) bool {
+	switch name {
+	case "name", "version", "major", "minor", "patch", "prerelease", "commit", "date":
+		return true
+	}
+	for _, prefix := range []string{"values.", "digest:", "image:"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup resolves a $(name) placeholder.
+func (c *templateContext) lookup(name string) (string, error) {
+	if value, ok := c.values[name]; ok {
+		return value, nil
+	}
+	if component, ok := strings.CutPrefix(name, "digest:"); ok {
+		image, err := c.image(component)
+		return image.Digest, err
+	}
+	if component, ok := strings.CutPrefix(name, "image:"); ok {
+		image, err := c.image(component)
+		return image.Reference + "@" + image.Digest, err
+	}
+
+	switch name {
+	case "commit":
+		return "", fmt.Errorf("placeholder $(commit) is only available in git repositories")
+	case "major", "minor", "patch", "prerelease":
+		return "", fmt.Errorf("placeholder $(%s) requires a semantic version, got %s", name, c.values["version"])
+	}
+	known := make([]string, 0, len(c.values))
+	for key := range c.values {
+		known = append(known, "$("+key+")")
+	}
+	sort.Strings(known)
+	return "", fmt.Errorf("unknown placeholder $(%s), known placeholders are %s, $(digest:<component>) and $(image:<component>); use $$( for a literal $(", name, strings.Join(known, ", "))
+}
+
+// image returns the image published for the latest release of component
+// reachable from the ref, as recorded in the release notes. The notes are
+// fetched from origin once, since fresh clones don't have them.
+func (c *templateContext) image(component string) (releaseImage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if image, ok := c.images[component]; ok {
+		return image, nil
+	}
+
+	if !isGitRepository(c.dir) {
+		return releaseImage{}, fmt.Errorf("images of %s are only available in git repositories", component)
+	}
+	tag, err := runGit(c.dir, "describe", "--tags", "--abbrev=0", "--match", component+"/v*", c.ref)
+	if err != nil {
+		return releaseImage{}, fmt.Errorf("no release of %s found at %s", component, c.ref)
+	}
+	commit, err := resolveCommit(c.dir, tag)
+	if err != nil {
+		return releaseImage{}, err
+	}
+	if c.fetchNotes {
+		if _, err := runGit(c.dir, "remote", "get-url", "origin"); err == nil {
+			if err := fetchReleaseNotes(c.ctx, c.dir); err != nil {
+				return releaseImage{}, err
+			}
+		}
+		c.fetchNotes = false
+	}
+	records, err := readReleaseRecords(c.dir, commit)
+	if err != nil {
+		return releaseImage{}, err
+	}
+	record := findReleaseRecord(records, component, strings.TrimPrefix(tag, component+"/v"))
+	if record == nil || len(record.Images) == 0 {
+		return releaseImage{}, fmt.Errorf("no image recorded for %s in %s", tag, releaseNotesRef)
+	}
+
+	// Every tag of the release is recorded, they must agree on the digest
+	image := record.Images[0]
+	for _, other := range record.Images[1:] {
+		if other.Digest != image.Digest {
+			return releaseImage{}, fmt.Errorf("%s has images with different digests recorded in %s, cannot choose one", tag, releaseNotesRef)
+		}
+	}
+	ref, err := name.ParseReference(image.Reference)
+	if err != nil {
+		return releaseImage{}, fmt.Errorf("invalid image reference recorded for %s: %v", tag, err)
+	}
+	image.Reference = ref.Context().Name()
+	c.images[component] = image
+	return image, nil
+}
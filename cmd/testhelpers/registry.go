@@ -7,12 +7,39 @@ import (
 	"github.com/google/go-containerregistry/pkg/registry"
 )
 
+// LocalRegistry starts an in-memory registry. Every request goes through the
+// intercepts first; an intercept writing a response ends the request there.
 func LocalRegistry(intercepts ...http.Handler) *httptest.Server {
+	return httptest.NewServer(registryHandler(intercepts...))
+}
+
+// registryHandler serves an in-memory registry behind the intercepts.
+func registryHandler(intercepts ...http.Handler) http.Handler {
 	registry := registry.New()
-	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &responseRecorder{ResponseWriter: w}
 		for _, i := range intercepts {
-			i.ServeHTTP(w, r)
+			i.ServeHTTP(recorder, r)
+			if recorder.written {
+				return
+			}
 		}
 		registry.ServeHTTP(w, r)
-	}))
+	})
+}
+
+// responseRecorder records whether a response was written.
+type responseRecorder struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	r.written = true
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.written = true
+	return r.ResponseWriter.Write(b)
 }
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"os/exec"
 	"strings"
 
 	"github.com/Masterminds/semver/v3"
@@ -10,25 +9,29 @@ import (
 )
 
 func NewVersionCmd() *cobra.Command {
-	return &cobra.Command{
+	var ref string
+	var fetch, remoteTags bool
+	cmd := &cobra.Command{
 		Use:   "version [name]",
 		Short: "Get the version of the current HEAD commit",
-		Long:  `Get the version of the current HEAD commit if it's tagged, otherwise throw an error.`,
+		Long:  `Get the version of the current HEAD commit (or --ref) if it's tagged, otherwise throw an error.`,
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			name := args[0]
 
-			// Get current commit hash
-			headCmd := exec.Command("git", "rev-parse", "HEAD")
-			headOutput, err := headCmd.Output()
+			// Make sure tags and history are available in shallow or tagless clones
+			if err := ensureVersionTags(cmd.Context(), "", name, fetch, remoteTags); err != nil {
+				return err
+			}
+
+			// Resolve the commit to inspect
+			currentCommit, err := resolveCommit("", ref)
 			if err != nil {
-				return fmt.Errorf("failed to get current commit: %v", err)
+				return err
 			}
-			currentCommit := strings.TrimSpace(string(headOutput))
 
 			// Get tags pointing at current commit
-			tagCmd := exec.Command("git", "tag", "--points-at", currentCommit, name+"/v*")
-			output, err := tagCmd.Output()
+			output, err := runGitContext(cmd.Context(), "", "tag", "--points-at", currentCommit, name+"/v*")
 			if err != nil || len(output) == 0 {
 				return fmt.Errorf("current HEAD is not tagged with a version")
 			}
@@ -45,4 +48,9 @@ func NewVersionCmd() *cobra.Command {
 			return nil
 		},
 	}
+
+	cmd.Flags().StringVar(&ref, "ref", "HEAD", "Git ref of the commit to inspect")
+	cmd.Flags().BoolVar(&fetch, "fetch", true, "Fetch history and tags from origin in shallow or tagless clones")
+	cmd.Flags().BoolVar(&remoteTags, "remote-tags", false, "Use the version tags on origin as the source of truth")
+	return cmd
 }
@@ -68,6 +68,12 @@ func TestVersionCmd(t *testing.T) {
 			wantErr:     true,
 			errContains: "current HEAD is not tagged with a version",
 		},
+		{
+			name:       "service tagged at explicit ref",
+			args:       []string{"service-a", "--ref", "HEAD~1"},
+			wantErr:    false,
+			wantOutput: "1.2.3\n",
+		},
 		{
 			name:        "no version tag",
 			args:        []string{"nonexistent-service"},
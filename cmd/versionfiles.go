@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultCommitMessage is the release commit message used when a component
+// does not configure one.
+const defaultCommitMessage = "Release {{.Name}} {{.Version}}"
+
+// releaseData is the template data available to release commit messages and
+// version file values.
+type releaseData struct {
+	Name    string
+	Version string
+	Tag     string
+}
+
+// updateVersionFiles writes the release version into the given files below
+// root. Files are edited in place so that formatting and comments survive.
+func updateVersionFiles(root string, files []versionFile, data releaseData) error {
+	for _, file := range files {
+		valueTemplate := file.Value
+		if valueTemplate == "" {
+			valueTemplate = "{{.Version}}"
+		}
+		value, err := renderTemplate("version file value", valueTemplate, data)
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(root, file.Path)
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to read version file: %v", err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read version file: %v", err)
+		}
+
+		switch file.Format {
+		case "yaml":
+			content, err = updateYAMLVersion(content, file.Key, value)
+		case "json":
+			content, err = updateJSONVersion(content, file.Key, value)
+		case "regex":
+			content, err = updateRegexVersion(content, file.Pattern, value)
+		default:
+			err = fmt.Errorf("unknown format %q, expected yaml, json or regex", file.Format)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update version file %s: %v", file.Path, err)
+		}
+
+		// Keep the permissions of the file, e.g. of executable scripts
+		if err := os.WriteFile(path, content, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to write version file: %v", err)
+		}
+	}
+	return nil
+}
+
+// updateYAMLVersion replaces the scalar at the dotted key path.
+func updateYAMLVersion(content []byte, key string, value string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil, fmt.Errorf("empty document")
+	}
+
+	node := doc.Content[0]
+	for _, segment := range strings.Split(key, ".") {
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == segment {
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			if index, err := strconv.Atoi(segment); err == nil && index >= 0 && index < len(node.Content) {
+				next = node.Content[index]
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("key %s not found", key)
+		}
+		node = next
+	}
+	if node.Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("key %s is not a scalar", key)
+	}
+
+	// Locate the scalar in the source; yaml columns count characters
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	if node.Line < 1 || node.Line > len(lines) {
+		return nil, fmt.Errorf("key %s has no source position", key)
+	}
+	start := 0
+	for _, line := range lines[:node.Line-1] {
+		start += len(line)
+	}
+	line := lines[node.Line-1]
+	for column := 1; column < node.Column && len(line) > 0; column++ {
+		_, size := utf8.DecodeRune(line)
+		start += size
+		line = line[size:]
+	}
+
+	var end int
+	var replacement string
+	switch node.Style {
+	case 0:
+		end = start + len(node.Value)
+		if end > len(content) || string(content[start:end]) != node.Value {
+			return nil, fmt.Errorf("key %s has an unsupported plain scalar", key)
+		}
+		replacement = value
+	case yaml.DoubleQuotedStyle:
+		end = closingQuote(content, start, '"')
+		replacement = strconv.Quote(value)
+	case yaml.SingleQuotedStyle:
+		end = closingQuote(content, start, '\'')
+		replacement = "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	default:
+		return nil, fmt.Errorf("key %s uses an unsupported scalar style", key)
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("key %s has an unterminated quoted scalar", key)
+	}
+
+	return append(append(append([]byte{}, content[:start]...), replacement...), content[end:]...), nil
+}
+
+// closingQuote returns the offset just past the quoted scalar starting at
+// start, or -1 if it is not terminated on the same line.
+func closingQuote(content []byte, start int, quote byte) int {
+	for i := start + 1; i < len(content) && content[i] != '\n'; i++ {
+		switch {
+		case quote == '"' && content[i] == '\\':
+			i++
+		case quote == '\'' && content[i] == '\'' && i+1 < len(content) && content[i+1] == '\'':
+			i++
+		case content[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// updateJSONVersion replaces the string at the dotted key path.
+func updateJSONVersion(content []byte, key string, value string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	start, end, err := findJSONValue(decoder, content, strings.Split(key, "."))
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %v", key, err)
+	}
+	if content[start] != '"' {
+		return nil, fmt.Errorf("key %s is not a string", key)
+	}
+	replacement, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(append([]byte{}, content[:start]...), replacement...), content[end:]...), nil
+}
+
+// findJSONValue returns the source offsets of the scalar value at path.
+func findJSONValue(decoder *json.Decoder, content []byte, path []string) (int, int, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return 0, 0, err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok || (delim != '{' && delim != '[') {
+		return 0, 0, fmt.Errorf("not found")
+	}
+
+	for index := 0; decoder.More(); index++ {
+		name := strconv.Itoa(index)
+		if delim == '{' {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return 0, 0, err
+			}
+			name = keyToken.(string)
+		}
+
+		if name != path[0] {
+			if err := skipJSONValue(decoder); err != nil {
+				return 0, 0, err
+			}
+			continue
+		}
+		if len(path) > 1 {
+			return findJSONValue(decoder, content, path[1:])
+		}
+
+		// The value starts after the separator following the previous token
+		start := int(decoder.InputOffset())
+		for start < len(content) && strings.ContainsRune(" \t\r\n:,", rune(content[start])) {
+			start++
+		}
+		token, err := decoder.Token()
+		if err != nil {
+			return 0, 0, err
+		}
+		if _, ok := token.(json.Delim); ok {
+			return 0, 0, fmt.Errorf("not a scalar")
+		}
+		return start, int(decoder.InputOffset()), nil
+	}
+	return 0, 0, fmt.Errorf("not found")
+}
+
+// skipJSONValue consumes the next value, including nested objects and arrays.
+func skipJSONValue(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := token.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// updateRegexVersion replaces the first capture group of every match.
+func updateRegexVersion(content []byte, pattern string, value string) ([]byte, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %v", err)
+	}
+	if re.NumSubexp() < 1 {
+		return nil, fmt.Errorf("pattern %q has no capture group", pattern)
+	}
+
+	matches := re.FindAllSubmatchIndex(content, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("pattern %q did not match", pattern)
+	}
+	var result []byte
+	last := 0
+	for _, match := range matches {
+		if match[2] < 0 {
+			continue
+		}
+		result = append(result, content[last:match[2]]...)
+		result = append(result, value...)
+		last = match[3]
+	}
+	return append(result, content[last:]...), nil
+}